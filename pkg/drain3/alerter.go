@@ -0,0 +1,202 @@
+package drain3
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"time"
+)
+
+type AlertType int
+
+const (
+	// AlertTypeNewTemplate is raised for a line creating a cluster after the warm-up
+	AlertTypeNewTemplate AlertType = iota
+	// AlertTypeRareTemplate is raised for a line of a cluster whose size is below the rarity percentile
+	AlertTypeRareTemplate
+)
+
+func (t AlertType) String() string {
+	switch t {
+	case AlertTypeNewTemplate:
+		return "new_template"
+	case AlertTypeRareTemplate:
+		return "rare_template"
+	default:
+		return "unknown"
+	}
+}
+
+type Alert struct {
+	Type      AlertType
+	Time      time.Time
+	ClusterId int64
+	Template  string
+	// size of the cluster before the line
+	Size int64
+	// size under which clusters are rare when the alert was raised, 0 for new templates
+	RareSize int64
+	Line     string
+}
+
+type AlertResult struct {
+	*LineResult
+	Alerts []*Alert
+}
+
+// Alerter raises alerts for lines of templates never seen before and of rare templates, once a warm-up period passed
+type Alerter struct {
+	miner *TemplateMiner
+
+	warmUpLines    int64
+	warmUpDuration time.Duration
+	rarePercentile float64
+	cooldown       time.Duration
+
+	lines         int64
+	firstLineTime time.Time
+	// rare size is refreshed every rareSizeRefreshLines lines and whenever a cluster is created
+	rareSize          int64
+	rareSizeLines     int64
+	lastClusterAlerts map[int64]time.Time
+
+	now func() time.Time
+}
+
+const rareSizeRefreshLines = 100
+
+type alerterOptionFn func(*Alerter)
+
+// WithWarmUpLines raises no alert for the first lines, 1000 by default
+func WithWarmUpLines(lines int64) alerterOptionFn {
+	return func(alerter *Alerter) {
+		alerter.warmUpLines = lines
+	}
+}
+
+// WithWarmUpDuration raises no alert until the duration passed since the first line
+func WithWarmUpDuration(duration time.Duration) alerterOptionFn {
+	return func(alerter *Alerter) {
+		alerter.warmUpDuration = duration
+	}
+}
+
+// WithRarePercentile raises an alert for lines of clusters whose size is at most the percentile (0-100) of the cluster sizes.
+// 0 disables rare template alerts
+func WithRarePercentile(percentile float64) alerterOptionFn {
+	return func(alerter *Alerter) {
+		alerter.rarePercentile = percentile
+	}
+}
+
+// WithAlertCooldown raises at most one alert per cluster within the duration, whatever their type
+func WithAlertCooldown(cooldown time.Duration) alerterOptionFn {
+	return func(alerter *Alerter) {
+		alerter.cooldown = cooldown
+	}
+}
+
+// WithAlerterClock replaces time.Now as the clock of the warm up, the cooldown and the alert times
+func WithAlerterClock(now func() time.Time) alerterOptionFn {
+	return func(alerter *Alerter) {
+		alerter.now = now
+	}
+}
+
+func NewAlerter(miner *TemplateMiner, options ...alerterOptionFn) (*Alerter, error) {
+	alerter := &Alerter{
+		miner:             miner,
+		warmUpLines:       1000,
+		lastClusterAlerts: map[int64]time.Time{},
+		now:               time.Now,
+	}
+
+	for _, option := range options {
+		option(alerter)
+	}
+
+	if alerter.warmUpLines < 0 || alerter.warmUpDuration < 0 || alerter.cooldown < 0 {
+		return nil, errors.New("warm-up and cooldown must not be negative")
+	} else if alerter.rarePercentile < 0 || alerter.rarePercentile > 100 {
+		return nil, errors.New("rare percentile must be between 0 and 100")
+	}
+
+	return alerter, nil
+}
+
+// AddLogLine mines a line with AddLogLine and returns the alerts it raised
+func (a *Alerter) AddLogLine(ctx context.Context, line string) (*AlertResult, error) {
+	lineResult, err := a.miner.AddLogLine(ctx, line)
+	if err != nil {
+		return nil, err
+	}
+
+	now := a.now()
+	if a.lines == 0 {
+		a.firstLineTime = now
+	}
+	a.lines++
+	if lineResult.UpdateType == ClusterUpdateTypeCreated {
+		a.rareSizeLines = 0
+	}
+
+	result := &AlertResult{LineResult: lineResult, Alerts: []*Alert{}}
+	if a.lines <= a.warmUpLines || now.Sub(a.firstLineTime) < a.warmUpDuration {
+		return result, nil
+	}
+
+	cluster := lineResult.Cluster
+	alert := &Alert{
+		Time:      now,
+		ClusterId: cluster.ClusterId,
+		Template:  lineResult.Template,
+		Size:      cluster.Size - 1,
+		Line:      line,
+	}
+
+	if lineResult.UpdateType == ClusterUpdateTypeCreated {
+		alert.Type = AlertTypeNewTemplate
+	} else if a.rarePercentile > 0 && alert.Size <= a.getRareSize(cluster) {
+		alert.Type = AlertTypeRareTemplate
+		alert.RareSize = a.rareSize
+	} else {
+		return result, nil
+	}
+
+	if lastAlert, exist := a.lastClusterAlerts[cluster.ClusterId]; exist && now.Sub(lastAlert) < a.cooldown {
+		return result, nil
+	}
+	if a.cooldown > 0 {
+		if _, exist := a.lastClusterAlerts[cluster.ClusterId]; !exist {
+			forgetEvictedClusters(a.miner.drain, a.lastClusterAlerts)
+		}
+		a.lastClusterAlerts[cluster.ClusterId] = now
+	}
+
+	result.Alerts = append(result.Alerts, alert)
+	return result, nil
+}
+
+// getRareSize returns the nearest rank percentile of the cluster sizes, counting the line just added to the cluster out
+func (a *Alerter) getRareSize(cluster *LogCluster) int64 {
+	if a.rareSizeLines > 0 && a.lines-a.rareSizeLines < rareSizeRefreshLines {
+		return a.rareSize
+	}
+
+	clusters := a.miner.drain.GetClusters()
+	sizes := make([]int64, 0, len(clusters))
+	for _, other := range clusters {
+		size := other.Size
+		if other.ClusterId == cluster.ClusterId {
+			size--
+		}
+		sizes = append(sizes, size)
+	}
+	slices.Sort(sizes)
+
+	rank := max(int(math.Ceil(a.rarePercentile/100*float64(len(sizes)))), 1)
+	a.rareSize = sizes[rank-1]
+	a.rareSizeLines = a.lines
+	return a.rareSize
+}
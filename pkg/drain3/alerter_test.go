@@ -0,0 +1,121 @@
+package drain3
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newTestAlerter(t *testing.T, options ...alerterOptionFn) *Alerter {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	alerter, err := NewAlerter(NewTemplateMiner(drain, NewMemoryPersistence()), options...)
+	require.NoError(t, err)
+	return alerter
+}
+
+func addAlertLines(t *testing.T, alerter *Alerter, lines ...string) []*Alert {
+	alerts := []*Alert{}
+	for _, line := range lines {
+		result, err := alerter.AddLogLine(context.Background(), line)
+		require.NoError(t, err)
+		alerts = append(alerts, result.Alerts...)
+	}
+	return alerts
+}
+
+func TestAlerterNewTemplates(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	alerter := newTestAlerter(t, WithWarmUpLines(3), WithAlerterClock(func() time.Time {
+		return now
+	}))
+
+	require.Empty(t, addAlertLines(t, alerter, "user 1 logged in", "disk full", "user 2 logged in"))
+
+	alerts := addAlertLines(t, alerter, "user 3 logged in", "connection reset by peer")
+	require.Equal(t, []*Alert{{
+		Type:      AlertTypeNewTemplate,
+		Time:      now,
+		ClusterId: 3,
+		Template:  "connection reset by peer",
+		Line:      "connection reset by peer",
+	}}, alerts)
+	require.Equal(t, "new_template", alerts[0].Type.String())
+}
+
+func TestAlerterWarmUpDuration(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	alerter := newTestAlerter(t, WithWarmUpLines(0), WithWarmUpDuration(time.Minute), WithAlerterClock(func() time.Time {
+		return now
+	}))
+
+	require.Empty(t, addAlertLines(t, alerter, "disk full", "connection reset by peer"))
+	now = now.Add(time.Minute)
+	require.Len(t, addAlertLines(t, alerter, "cache cleared"), 1)
+}
+
+func TestAlerterRareTemplates(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	alerter := newTestAlerter(t, WithWarmUpLines(3), WithRarePercentile(25), WithAlertCooldown(time.Minute), WithAlerterClock(func() time.Time {
+		return now
+	}))
+
+	lines := []string{"disk full", "connection reset by peer", "cache cleared"}
+	for i := 0; i < 20; i++ {
+		lines = append(lines, fmt.Sprintf("user %d logged in", i), fmt.Sprintf("request %d done", i))
+		if i%2 == 0 {
+			lines = append(lines, "cache cleared")
+		}
+	}
+	alerts := addAlertLines(t, alerter, lines...)
+
+	rareClusterIds := []int64{}
+	for _, alert := range alerts {
+		if alert.Type == AlertTypeRareTemplate {
+			rareClusterIds = append(rareClusterIds, alert.ClusterId)
+		}
+	}
+	// cache cleared is rare until it outgrows the smallest quarter, and alerts at most once per cooldown
+	require.Equal(t, []int64{3}, rareClusterIds)
+
+	now = now.Add(time.Minute)
+	alerts = addAlertLines(t, alerter, "disk full")
+	require.Len(t, alerts, 1)
+	require.Equal(t, AlertTypeRareTemplate, alerts[0].Type)
+	require.Equal(t, int64(1), alerts[0].ClusterId)
+	require.Equal(t, int64(1), alerts[0].Size)
+	require.Equal(t, int64(1), alerts[0].RareSize)
+
+	// a frequent template is not rare
+	require.Empty(t, addAlertLines(t, alerter, "user 99 logged in"))
+}
+
+func TestAlerterForgetsEvictedClusters(t *testing.T) {
+	drain, err := NewDrain(WithMaxCluster(2))
+	require.NoError(t, err)
+	alerter, err := NewAlerter(NewTemplateMiner(drain, NewMemoryPersistence()), WithWarmUpLines(0), WithAlertCooldown(time.Hour))
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.Len(t, addAlertLines(t, alerter, fmt.Sprintf("template%d", i)), 1)
+	}
+
+	// cooldowns of evicted clusters are dropped once they outnumber twice the live clusters
+	require.LessOrEqual(t, len(alerter.lastClusterAlerts), 4)
+	for clusterId := range alerter.lastClusterAlerts {
+		require.GreaterOrEqual(t, clusterId, int64(6))
+	}
+}
+
+func TestNewAlerterErrors(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence())
+
+	for _, option := range []alerterOptionFn{WithWarmUpLines(-1), WithWarmUpDuration(-time.Second), WithAlertCooldown(-time.Second), WithRarePercentile(101)} {
+		_, err := NewAlerter(miner, option)
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,637 @@
+package drain3
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// archives start with a magic and a format version followed by the parameter strings of the miner and by blocks.
+// each block is an index holding its template dictionary, then a flate compressed body holding its lines,
+// both uvarint length prefixed, so that queries can skip blocks without inflating them
+var archiveMagic = []byte("GD3A")
+
+const archiveVersion = 2
+
+const (
+	// maxArchiveBlockSize bounds both the compressed and the decompressed size of a block, so that reading a corrupted archive
+	// cannot allocate without limit
+	maxArchiveBlockSize = 128 << 20
+	// blocks are written once their lines reach this size whatever their count, leaving room for the layouts and indexes
+	archiveBlockLineBytes = maxArchiveBlockSize / 4
+)
+
+// Compressor writes lines as the template of their cluster plus their parameter values, in the spirit of CLP.
+// a block holds a template dictionary, the template of every line in order, and the parameters of each template slot as a column.
+// lines which would not be reconstructed byte for byte from their template are stored as is
+type Compressor struct {
+	miner      *TemplateMiner
+	blockLines int
+}
+
+type compressorOptionFn func(*Compressor)
+
+// WithBlockLines sets how many lines a block holds, 10000 by default. larger blocks compress better but take more memory
+func WithBlockLines(blockLines int) compressorOptionFn {
+	return func(compressor *Compressor) {
+		compressor.blockLines = blockLines
+	}
+}
+
+func NewCompressor(miner *TemplateMiner, options ...compressorOptionFn) *Compressor {
+	compressor := &Compressor{
+		miner:      miner,
+		blockLines: 10000,
+	}
+
+	for _, option := range options {
+		option(compressor)
+	}
+
+	return compressor
+}
+
+// Compress mines every line read from r and writes the archive, the state of the miner is saved once at the end.
+// lines keep their terminator, "\n", "\r\n" or none for a last line without newline.
+// a line must fit in a block, so Compress fails on lines of about 128MB or more
+func (c *Compressor) Compress(ctx context.Context, r io.Reader, w io.Writer) error {
+	if c.blockLines < 1 {
+		return errors.New("block lines must be at least 1")
+	}
+
+	header := newBinaryWriter()
+	header.buf.Write(archiveMagic)
+	header.writeUvarint(archiveVersion)
+	for _, str := range []string{c.miner.drain.ParamStr, c.variableParamStr()} {
+		header.writeUvarint(uint64(len(str)))
+		header.buf.WriteString(str)
+	}
+	if _, err := w.Write(header.buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write archive header: %w", err)
+	}
+
+	reader := bufio.NewReader(r)
+	lines, terminators := []string{}, []string{}
+	clusterIds := []int64{}
+	lineBytes := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read lines: %w", err)
+		} else if line == "" {
+			break
+		}
+		line, terminator := splitLineTerminator(line)
+
+		cluster, _, addErr := c.miner.drain.AddLogMessage(line)
+		if addErr != nil {
+			return fmt.Errorf("failed to add line: %w", addErr)
+		}
+
+		lines = append(lines, line)
+		terminators = append(terminators, terminator)
+		clusterIds = append(clusterIds, cluster.ClusterId)
+		lineBytes += len(line)
+		if len(lines) == c.blockLines || lineBytes >= archiveBlockLineBytes {
+			if err := c.writeBlock(w, lines, terminators, clusterIds); err != nil {
+				return err
+			}
+			lines, terminators, clusterIds = lines[:0], terminators[:0], clusterIds[:0]
+			lineBytes = 0
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if len(lines) > 0 {
+		if err := c.writeBlock(w, lines, terminators, clusterIds); err != nil {
+			return err
+		}
+	}
+
+	if err := c.miner.SaveState(ctx); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+
+	return nil
+}
+
+// variableParamStr is written in the archive header, empty when templates have no variable parameter
+func (c *Compressor) variableParamStr() string {
+	if !c.miner.drain.VariableLength {
+		return ""
+	}
+	return c.miner.drain.VariableParamStr
+}
+
+func (c *Compressor) writeBlock(w io.Writer, lines []string, terminators []string, clusterIds []int64) error {
+	block := &ArchiveBlock{
+		Templates:       []*ArchiveTemplate{},
+		TemplateIndexes: make([]int, 0, len(lines)),
+		Layouts:         make([]*MessageLayout, 0, len(lines)),
+		Terminators:     terminators,
+		RawLines:        []string{},
+	}
+
+	// templates may have changed since their lines were mined, so every line is extracted against the final template of its cluster
+	clusterIdToTemplate := map[int64]int{}
+	for i, line := range lines {
+		layout := c.miner.drain.getMessageLayout(line)
+
+		templateIndex := -1
+		if cluster, exist := c.miner.drain.IdToCluster.Peek(clusterIds[i]); exist && layout != nil {
+			template := cluster.GetTemplate()
+			parameters := c.miner.ExtractParameters(template, line[len(layout.Leading):len(line)-len(layout.Trailing)])
+
+			values := make([]string, 0, len(parameters))
+			for _, parameter := range parameters {
+				values = append(values, parameter.Value)
+			}
+
+			if rendered, err := c.miner.Render(cluster, values, layout); parameters != nil && err == nil && rendered == line {
+				index, exist := clusterIdToTemplate[cluster.ClusterId]
+				if !exist {
+					index = len(block.Templates)
+					clusterIdToTemplate[cluster.ClusterId] = index
+					block.Templates = append(block.Templates, &ArchiveTemplate{
+						ClusterId:  cluster.ClusterId,
+						Template:   template,
+						Parameters: make([][]string, len(values)),
+					})
+				}
+
+				archiveTemplate := block.Templates[index]
+				for slot, value := range values {
+					archiveTemplate.Parameters[slot] = append(archiveTemplate.Parameters[slot], value)
+				}
+				templateIndex = index
+			}
+		}
+
+		if templateIndex < 0 {
+			block.RawLines = append(block.RawLines, line)
+			layout = nil
+		}
+		block.TemplateIndexes = append(block.TemplateIndexes, templateIndex)
+		block.Layouts = append(block.Layouts, layout)
+	}
+
+	index, body := block.marshalIndex(), block.marshal()
+	if len(index) > maxArchiveBlockSize {
+		return fmt.Errorf("block index of %d bytes exceeds the maximum of %d", len(index), maxArchiveBlockSize)
+	} else if len(body) > maxArchiveBlockSize {
+		return fmt.Errorf("block of %d bytes exceeds the maximum of %d", len(body), maxArchiveBlockSize)
+	}
+
+	var compressed bytes.Buffer
+	flateWriter, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		return fmt.Errorf("failed to create flate writer: %w", err)
+	}
+	if _, err := flateWriter.Write(body); err != nil {
+		return fmt.Errorf("failed to compress block: %w", err)
+	}
+	if err := flateWriter.Close(); err != nil {
+		return fmt.Errorf("failed to compress block: %w", err)
+	}
+
+	for _, data := range [][]byte{index, compressed.Bytes()} {
+		length := newBinaryWriter()
+		length.writeUvarint(uint64(len(data)))
+		if _, err := w.Write(length.buf.Bytes()); err != nil {
+			return fmt.Errorf("failed to write block: %w", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("failed to write block: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ArchiveTemplate is a template of a block with the values of its lines, one column per parameter slot
+type ArchiveTemplate struct {
+	ClusterId  int64
+	Template   string
+	Parameters [][]string
+}
+
+// row returns the values of the index-th line of the template
+func (t *ArchiveTemplate) row(index int) []string {
+	values := make([]string, 0, len(t.Parameters))
+	for _, column := range t.Parameters {
+		values = append(values, column[index])
+	}
+	return values
+}
+
+// ArchiveBlock holds a block of lines as written by the compressor
+type ArchiveBlock struct {
+	Templates []*ArchiveTemplate
+	// index in Templates of the template of every line, -1 for lines stored as is
+	TemplateIndexes []int
+	// layout of every line rendered from its template, nil for lines stored as is
+	Layouts []*MessageLayout
+	// terminator of every line, "\n", "\r\n" or empty for a last line without newline
+	Terminators []string
+	RawLines    []string
+
+	paramStr         string
+	variableParamStr string
+}
+
+func (b *ArchiveBlock) Len() int {
+	return len(b.TemplateIndexes)
+}
+
+// Lines reconstructs every line of the block in order, without their terminators
+func (b *ArchiveBlock) Lines() ([]string, error) {
+	lines := make([]string, 0, b.Len())
+	lineToValue := make([]int, len(b.Templates))
+	rawIndex := 0
+
+	for i, templateIndex := range b.TemplateIndexes {
+		if templateIndex < 0 {
+			lines = append(lines, b.RawLines[rawIndex])
+			rawIndex++
+			continue
+		}
+
+		archiveTemplate := b.Templates[templateIndex]
+		line, err := b.renderLine(i, archiveTemplate, archiveTemplate.row(lineToValue[templateIndex]))
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+		lineToValue[templateIndex]++
+	}
+
+	return lines, nil
+}
+
+func (b *ArchiveBlock) renderLine(lineIndex int, archiveTemplate *ArchiveTemplate, values []string) (string, error) {
+	line, err := renderTemplate(strings.Split(archiveTemplate.Template, " "), values, b.Layouts[lineIndex], b.paramStr, b.variableParamStr)
+	if err != nil {
+		return "", fmt.Errorf("failed to render line %d: %w", lineIndex, err)
+	}
+	return line, nil
+}
+
+// archiveBlockIndex is the part of a block stored outside its compressed body: its template dictionary and its line counts
+type archiveBlockIndex struct {
+	lineCount    int
+	rawLineCount int
+	// templates without their parameter values, only their slot count
+	templates []*ArchiveTemplate
+}
+
+func (b *ArchiveBlock) marshalIndex() []byte {
+	index := newBinaryWriter()
+
+	index.writeUvarint(uint64(b.Len()))
+	index.writeUvarint(uint64(len(b.RawLines)))
+	index.writeUvarint(uint64(len(b.Templates)))
+	for _, archiveTemplate := range b.Templates {
+		index.writeVarint(archiveTemplate.ClusterId)
+		index.writeString(archiveTemplate.Template)
+		index.writeUvarint(uint64(len(archiveTemplate.Parameters)))
+	}
+
+	block := newBinaryWriter()
+	block.writeTable(index.table)
+	block.buf.Write(index.buf.Bytes())
+	return block.buf.Bytes()
+}
+
+func unmarshalArchiveBlockIndex(data []byte) (*archiveBlockIndex, error) {
+	reader := &binaryReader{data: data}
+	reader.readTable()
+
+	// lines are counted again from the body, so their counts are only bounded here
+	index := &archiveBlockIndex{
+		lineCount:    int(min(reader.readUvarint(), maxArchiveBlockSize)),
+		rawLineCount: int(min(reader.readUvarint(), maxArchiveBlockSize)),
+		templates:    []*ArchiveTemplate{},
+	}
+	templateCount := reader.readUvarint()
+	for i := uint64(0); i < templateCount && reader.err == nil; i++ {
+		archiveTemplate := &ArchiveTemplate{}
+		archiveTemplate.ClusterId = reader.readVarint()
+		archiveTemplate.Template = reader.readString()
+		// every parameter is at least a token of the template
+		slotCount := reader.readUvarint()
+		if slotCount > uint64(len(archiveTemplate.Template))+1 {
+			return nil, fmt.Errorf("template %d has more slots than tokens", archiveTemplate.ClusterId)
+		}
+		archiveTemplate.Parameters = make([][]string, slotCount)
+		index.templates = append(index.templates, archiveTemplate)
+	}
+
+	if reader.err != nil {
+		return nil, fmt.Errorf("failed to read block index: %w", reader.err)
+	}
+	return index, nil
+}
+
+func (b *ArchiveBlock) marshal() []byte {
+	body := newBinaryWriter()
+
+	for _, templateIndex := range b.TemplateIndexes {
+		body.writeVarint(int64(templateIndex))
+	}
+	for i, layout := range b.Layouts {
+		body.writeString(b.Terminators[i])
+		if layout == nil {
+			continue
+		}
+
+		body.writeString(layout.Leading)
+		body.writeString(layout.Trailing)
+		// most messages are separated by single spaces, which only takes their count
+		body.writeUvarint(uint64(len(layout.Separators)))
+		singleSpaces := !slices.ContainsFunc(layout.Separators, func(separator string) bool { return separator != " " })
+		body.writeBool(singleSpaces)
+		if !singleSpaces {
+			for _, separator := range layout.Separators {
+				body.writeString(separator)
+			}
+		}
+	}
+
+	// the columns of a template follow each other, so that similar values are close for flate
+	for _, archiveTemplate := range b.Templates {
+		for _, column := range archiveTemplate.Parameters {
+			body.writeStrings(column)
+		}
+	}
+	body.writeStrings(b.RawLines)
+
+	block := newBinaryWriter()
+	block.writeTable(body.table)
+	block.buf.Write(body.buf.Bytes())
+	return block.buf.Bytes()
+}
+
+func unmarshalArchiveBlock(index *archiveBlockIndex, data []byte) (*ArchiveBlock, error) {
+	reader := &binaryReader{data: data}
+	reader.readTable()
+
+	block := &ArchiveBlock{Templates: index.templates}
+	for i := 0; i < index.lineCount && reader.err == nil; i++ {
+		templateIndex := int(reader.readVarint())
+		if templateIndex < -1 || templateIndex >= len(block.Templates) {
+			return nil, fmt.Errorf("template index %d out of the %d templates of the block", templateIndex, len(block.Templates))
+		}
+		block.TemplateIndexes = append(block.TemplateIndexes, templateIndex)
+	}
+	for _, templateIndex := range block.TemplateIndexes {
+		if reader.err != nil {
+			break
+		}
+
+		block.Terminators = append(block.Terminators, reader.readString())
+		if templateIndex < 0 {
+			block.Layouts = append(block.Layouts, nil)
+			continue
+		}
+
+		layout := &MessageLayout{Leading: reader.readString(), Trailing: reader.readString()}
+		// every separator stands between tokens whose bytes are in the block, which bounds their count
+		separatorCount := reader.readUvarint()
+		if separatorCount > uint64(len(data)) {
+			return nil, fmt.Errorf("layout of %d separators exceeds the block", separatorCount)
+		}
+		singleSpaces := reader.readBool()
+		layout.Separators = make([]string, 0, separatorCount)
+		for j := uint64(0); j < separatorCount && reader.err == nil; j++ {
+			separator := " "
+			if !singleSpaces {
+				separator = reader.readString()
+			}
+			layout.Separators = append(layout.Separators, separator)
+		}
+		block.Layouts = append(block.Layouts, layout)
+	}
+
+	for _, archiveTemplate := range block.Templates {
+		for slot := range archiveTemplate.Parameters {
+			archiveTemplate.Parameters[slot] = reader.readStrings()
+		}
+	}
+	block.RawLines = reader.readStrings()
+
+	if reader.err != nil {
+		return nil, fmt.Errorf("failed to read block: %w", reader.err)
+	}
+
+	// every line must have its values, so that reconstructing lines cannot go out of range
+	lineCounts := make([]int, len(block.Templates))
+	rawLineCount := 0
+	for _, templateIndex := range block.TemplateIndexes {
+		if templateIndex < 0 {
+			rawLineCount++
+		} else {
+			lineCounts[templateIndex]++
+		}
+	}
+	if block.Len() != index.lineCount {
+		return nil, fmt.Errorf("block has %d lines instead of %d", block.Len(), index.lineCount)
+	} else if rawLineCount != len(block.RawLines) || rawLineCount != index.rawLineCount {
+		return nil, fmt.Errorf("block has %d raw lines instead of %d", len(block.RawLines), rawLineCount)
+	}
+	for i, archiveTemplate := range block.Templates {
+		for _, column := range archiveTemplate.Parameters {
+			if len(column) != lineCounts[i] {
+				return nil, fmt.Errorf("template %d has %d values instead of %d", archiveTemplate.ClusterId, len(column), lineCounts[i])
+			}
+		}
+	}
+
+	return block, nil
+}
+
+// ArchiveReader reads the blocks of an archive one by one
+type ArchiveReader struct {
+	reader           *bufio.Reader
+	paramStr         string
+	variableParamStr string
+}
+
+func NewArchiveReader(r io.Reader) (*ArchiveReader, error) {
+	reader := bufio.NewReader(r)
+
+	magic := make([]byte, len(archiveMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil {
+		return nil, fmt.Errorf("failed to read archive magic: %w", err)
+	} else if !bytes.Equal(magic, archiveMagic) {
+		return nil, errors.New("not an archive")
+	}
+
+	version, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive version: %w", err)
+	} else if version != archiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d", version)
+	}
+
+	strs := []string{}
+	for i := 0; i < 2; i++ {
+		length, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive header: %w", err)
+		} else if length > 1024 {
+			return nil, fmt.Errorf("archive header string of %d bytes is too long", length)
+		}
+		str := make([]byte, length)
+		if _, err := io.ReadFull(reader, str); err != nil {
+			return nil, fmt.Errorf("failed to read archive header: %w", err)
+		}
+		strs = append(strs, string(str))
+	}
+
+	return &ArchiveReader{
+		reader:           reader,
+		paramStr:         strs[0],
+		variableParamStr: strs[1],
+	}, nil
+}
+
+// Next returns the next block, io.EOF once every block was read
+func (r *ArchiveReader) Next() (*ArchiveBlock, error) {
+	index, err := r.nextIndex()
+	if err != nil {
+		return nil, err
+	}
+	return r.readBody(index)
+}
+
+// nextIndex reads the index of the next block, which must be followed by readBody or skipBody
+func (r *ArchiveReader) nextIndex() (*archiveBlockIndex, error) {
+	length, err := binary.ReadUvarint(r.reader)
+	if err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read block index length: %w", err)
+	} else if length > maxArchiveBlockSize {
+		return nil, fmt.Errorf("block index of %d bytes exceeds the maximum of %d", length, maxArchiveBlockSize)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return nil, fmt.Errorf("failed to read block index: %w", err)
+	}
+	return unmarshalArchiveBlockIndex(data)
+}
+
+func (r *ArchiveReader) readBodyLength() (int64, error) {
+	length, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read block length: %w", err)
+	} else if length > maxArchiveBlockSize {
+		return 0, fmt.Errorf("block of %d bytes exceeds the maximum of %d", length, maxArchiveBlockSize)
+	}
+	return int64(length), nil
+}
+
+// skipBody skips the body of the block whose index was just read without inflating it
+func (r *ArchiveReader) skipBody() error {
+	length, err := r.readBodyLength()
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.reader.Discard(int(length)); err != nil {
+		return fmt.Errorf("failed to skip block: %w", err)
+	}
+	return nil
+}
+
+// readBody reads the body of the block whose index was just read
+func (r *ArchiveReader) readBody(index *archiveBlockIndex) (*ArchiveBlock, error) {
+	length, err := r.readBodyLength()
+	if err != nil {
+		return nil, err
+	}
+
+	// one more byte than the maximum tells a block decompressing beyond it
+	data, err := io.ReadAll(io.LimitReader(flate.NewReader(io.LimitReader(r.reader, length)), maxArchiveBlockSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress block: %w", err)
+	} else if len(data) > maxArchiveBlockSize {
+		return nil, fmt.Errorf("decompressed block exceeds the maximum of %d bytes", maxArchiveBlockSize)
+	}
+
+	block, err := unmarshalArchiveBlock(index, data)
+	if err != nil {
+		return nil, err
+	}
+	block.paramStr = r.paramStr
+	block.variableParamStr = r.variableParamStr
+
+	return block, nil
+}
+
+// Decompress writes every line of an archive followed by its original terminator
+func Decompress(r io.Reader, w io.Writer) error {
+	reader, err := NewArchiveReader(r)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(w)
+	for {
+		block, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		lines, err := block.Lines()
+		if err != nil {
+			return err
+		}
+		for i, line := range lines {
+			writer.WriteString(line)
+			writer.WriteString(block.Terminators[i])
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write lines: %w", err)
+	}
+
+	return nil
+}
+
+// splitLineTerminator splits a line read up to a newline into its content and its terminator
+func splitLineTerminator(line string) (string, string) {
+	if !strings.HasSuffix(line, "\n") {
+		return line, ""
+	} else if strings.HasSuffix(line, "\r\n") {
+		return line[:len(line)-2], "\r\n"
+	}
+	return line[:len(line)-1], "\n"
+}
+
+// splitOuterSpaces splits a line into the whitespace drain trims and its content
+func splitOuterSpaces(line string) (string, string, string) {
+	content := strings.TrimLeftFunc(line, unicode.IsSpace)
+	leading := line[:len(line)-len(content)]
+	trimmed := strings.TrimRightFunc(content, unicode.IsSpace)
+	return leading, trimmed, content[len(trimmed):]
+}
@@ -0,0 +1,286 @@
+package drain3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type queryField int
+
+const (
+	queryFieldTemplate queryField = iota
+	queryFieldSlot
+)
+
+type queryOperator byte
+
+const (
+	queryOperatorEquals   queryOperator = '='
+	queryOperatorContains queryOperator = '~'
+)
+
+type queryCondition struct {
+	field    queryField
+	slot     int
+	operator queryOperator
+	value    string
+	// template patterns are globs, compiled once
+	pattern *regexp.Regexp
+}
+
+// ArchiveQuery selects lines of an archive, every condition of the query has to hold:
+//
+//	template=<glob>  the template of the line matches the glob, where * matches any text
+//	template~<text>  the template of the line contains the text
+//	$<slot>=<value>  the parameter at the slot, counted from 0, equals the value
+//	$<slot>~<value>  the parameter at the slot contains the value
+//
+// conditions are separated by spaces, values holding spaces are quoted like go strings, e.g. template="user * logged in" $0~adm.
+// lines stored as is in the archive have neither template nor parameters, so every condition is checked against the whole line,
+// template globs as globs and other values as substrings, and their matches are flagged as unverified
+type ArchiveQuery struct {
+	conditions []*queryCondition
+}
+
+func ParseArchiveQuery(query string) (*ArchiveQuery, error) {
+	terms, err := splitQueryTerms(query)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := []*queryCondition{}
+	for _, term := range terms {
+		index := strings.IndexAny(term, "=~")
+		if index <= 0 {
+			return nil, fmt.Errorf("invalid condition %q, expected a field, = or ~ and a value", term)
+		}
+
+		condition := &queryCondition{operator: queryOperator(term[index])}
+		field, value := term[:index], term[index+1:]
+		if strings.HasPrefix(value, `"`) {
+			if value, err = strconv.Unquote(value); err != nil {
+				return nil, fmt.Errorf("invalid quoted value in condition %q: %w", term, err)
+			}
+		}
+		condition.value = value
+
+		if field == "template" {
+			condition.field = queryFieldTemplate
+			if condition.operator == queryOperatorEquals {
+				pattern := strings.ReplaceAll(regexp.QuoteMeta(value), `\*`, `.*`)
+				condition.pattern = regexp.MustCompile("^" + pattern + "$")
+			}
+		} else if slot, err := strconv.Atoi(strings.TrimPrefix(field, "$")); strings.HasPrefix(field, "$") && err == nil && slot >= 0 {
+			condition.field = queryFieldSlot
+			condition.slot = slot
+		} else {
+			return nil, fmt.Errorf("unknown field %q, expected template or $<slot>", field)
+		}
+
+		conditions = append(conditions, condition)
+	}
+
+	return &ArchiveQuery{conditions: conditions}, nil
+}
+
+// splitQueryTerms splits a query on the spaces outside of quoted values
+func splitQueryTerms(query string) ([]string, error) {
+	terms := []string{}
+	term := strings.Builder{}
+	quoted, escaped := false, false
+
+	for _, char := range query {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && char == '\\':
+			escaped = true
+		case char == '"':
+			quoted = !quoted
+		case !quoted && char == ' ':
+			if term.Len() > 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+			continue
+		}
+		term.WriteRune(char)
+	}
+
+	if quoted {
+		return nil, errors.New("unterminated quoted value in query")
+	}
+	if term.Len() > 0 {
+		terms = append(terms, term.String())
+	}
+	return terms, nil
+}
+
+// matchTemplate tells whether lines of the template may match, from the template dictionary only
+func (q *ArchiveQuery) matchTemplate(archiveTemplate *ArchiveTemplate) bool {
+	for _, condition := range q.conditions {
+		switch condition.field {
+		case queryFieldTemplate:
+			if !condition.match(archiveTemplate.Template) {
+				return false
+			}
+		case queryFieldSlot:
+			if condition.slot >= len(archiveTemplate.Parameters) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchRow tells whether the index-th line of a template matching the query matches its parameter conditions
+func (q *ArchiveQuery) matchRow(archiveTemplate *ArchiveTemplate, index int) bool {
+	for _, condition := range q.conditions {
+		if condition.field == queryFieldSlot && !condition.match(archiveTemplate.Parameters[condition.slot][index]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRawLine tells whether a line stored as is may match, each condition only needing to hold somewhere in the line
+func (q *ArchiveQuery) matchRawLine(line string) bool {
+	for _, condition := range q.conditions {
+		if condition.pattern != nil {
+			if !condition.pattern.MatchString(line) {
+				return false
+			}
+		} else if !strings.Contains(line, condition.value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *queryCondition) match(value string) bool {
+	if c.pattern != nil {
+		return c.pattern.MatchString(value)
+	} else if c.operator == queryOperatorContains {
+		return strings.Contains(value, c.value)
+	}
+	return value == c.value
+}
+
+// ArchiveMatch is a line of an archive matching a query
+type ArchiveMatch struct {
+	// index of the line in the archive, counted from 0
+	LineNumber int64
+	// cluster of the template of the line, 0 for lines stored as is
+	ClusterId  int64
+	Line       string
+	Parameters []string
+	// set for lines stored as is matching a non empty query, whose conditions were checked against the whole line only
+	Unverified bool
+}
+
+// SearchArchive returns the lines of an archive matching the query in order.
+// templates of each block are matched first from the block index, so that blocks having neither a matching template nor
+// a raw line are skipped without being inflated, only the parameter columns of matching templates are scanned
+// and only matching lines are reconstructed
+func SearchArchive(ctx context.Context, r io.Reader, query *ArchiveQuery) ([]*ArchiveMatch, error) {
+	reader, err := NewArchiveReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []*ArchiveMatch{}
+	lineNumber := int64(0)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		index, err := reader.nextIndex()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if !query.matchIndex(index) {
+			if err := reader.skipBody(); err != nil {
+				return nil, err
+			}
+			lineNumber += int64(index.lineCount)
+			continue
+		}
+
+		block, err := reader.readBody(index)
+		if err != nil {
+			return nil, err
+		}
+
+		blockMatches, err := query.searchBlock(block, lineNumber)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, blockMatches...)
+		lineNumber += int64(block.Len())
+	}
+
+	return matches, nil
+}
+
+// matchIndex tells whether lines of a block may match, from its index only
+func (q *ArchiveQuery) matchIndex(index *archiveBlockIndex) bool {
+	return index.rawLineCount > 0 || slices.ContainsFunc(index.templates, q.matchTemplate)
+}
+
+func (q *ArchiveQuery) searchBlock(block *ArchiveBlock, firstLineNumber int64) ([]*ArchiveMatch, error) {
+	matchAll := len(q.conditions) == 0
+
+	candidates := make([]bool, len(block.Templates))
+	for i, archiveTemplate := range block.Templates {
+		candidates[i] = q.matchTemplate(archiveTemplate)
+	}
+
+	matches := []*ArchiveMatch{}
+	rows := make([]int, len(block.Templates))
+	rawIndex := 0
+	for i, templateIndex := range block.TemplateIndexes {
+		if templateIndex < 0 {
+			if line := block.RawLines[rawIndex]; q.matchRawLine(line) {
+				matches = append(matches, &ArchiveMatch{
+					LineNumber: firstLineNumber + int64(i),
+					Line:       line,
+					Unverified: !matchAll,
+				})
+			}
+			rawIndex++
+			continue
+		}
+
+		row := rows[templateIndex]
+		rows[templateIndex]++
+
+		archiveTemplate := block.Templates[templateIndex]
+		if !candidates[templateIndex] || !q.matchRow(archiveTemplate, row) {
+			continue
+		}
+
+		values := archiveTemplate.row(row)
+		line, err := block.renderLine(i, archiveTemplate, values)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, &ArchiveMatch{
+			LineNumber: firstLineNumber + int64(i),
+			ClusterId:  archiveTemplate.ClusterId,
+			Line:       line,
+			Parameters: values,
+		})
+	}
+
+	return matches, nil
+}
@@ -0,0 +1,133 @@
+package drain3
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSearchArchive(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	lines := []string{
+		"user alice logged in from 10.0.0.1",
+		"user bob logged in from 10.0.0.2",
+		"disk /dev/sda1 is full",
+		"user admin logged in from 192.168.1.7",
+		"disk /dev/sdb2 is full",
+	}
+	archive := compressLines(t, drain, lines, WithBlockLines(4))
+
+	search := func(query string) []int64 {
+		parsed, err := ParseArchiveQuery(query)
+		require.NoError(t, err)
+		matches, err := SearchArchive(context.Background(), bytes.NewReader(archive), parsed)
+		require.NoError(t, err)
+
+		lineNumbers := []int64{}
+		for _, match := range matches {
+			require.Equal(t, lines[match.LineNumber], match.Line)
+			lineNumbers = append(lineNumbers, match.LineNumber)
+		}
+		return lineNumbers
+	}
+
+	require.Equal(t, []int64{0, 1, 2, 3, 4}, search(""))
+	require.Equal(t, []int64{0, 1, 3}, search(`template="user * logged in from *"`))
+	require.Equal(t, []int64{2, 4}, search("template~disk"))
+	require.Equal(t, []int64{}, search("template=disk"))
+	require.Equal(t, []int64{1}, search("template~user $0=bob"))
+	require.Equal(t, []int64{0, 1}, search("$1~10.0."))
+	require.Equal(t, []int64{4}, search(`template~disk $0="/dev/sdb2"`))
+	require.Equal(t, []int64{}, search("$5=x"))
+}
+
+func TestSearchArchiveParameters(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	archive := compressLines(t, drain, []string{"took 3 ms", "took 5 ms"})
+
+	query, err := ParseArchiveQuery("$0=5")
+	require.NoError(t, err)
+	matches, err := SearchArchive(context.Background(), bytes.NewReader(archive), query)
+	require.NoError(t, err)
+	require.Equal(t, []*ArchiveMatch{{LineNumber: 1, ClusterId: 1, Line: "took 5 ms", Parameters: []string{"5"}}}, matches)
+}
+
+func TestParseArchiveQueryErrors(t *testing.T) {
+	for _, query := range []string{"template", "=x", "level=info", "$x=1", "$-1=1", `template="user`, `$0="\q"`} {
+		_, err := ParseArchiveQuery(query)
+		require.Error(t, err, query)
+	}
+
+	query, err := ParseArchiveQuery(`template="a \"b\" c"  $2~d`)
+	require.NoError(t, err)
+	require.Len(t, query.conditions, 2)
+	require.Equal(t, `a "b" c`, query.conditions[0].value)
+	require.Equal(t, 2, query.conditions[1].slot)
+}
+
+func TestSearchArchiveRawLines(t *testing.T) {
+	// the first cluster is evicted before its block is written, so its line is stored as is
+	drain, err := NewDrain(WithMaxCluster(1))
+	require.NoError(t, err)
+	lines := []string{"user alice logged in", "disk /dev/sda1 is full"}
+	archive := compressLines(t, drain, lines)
+
+	search := func(query string) []*ArchiveMatch {
+		parsed, err := ParseArchiveQuery(query)
+		require.NoError(t, err)
+		matches, err := SearchArchive(context.Background(), bytes.NewReader(archive), parsed)
+		require.NoError(t, err)
+		return matches
+	}
+
+	rawMatch := &ArchiveMatch{LineNumber: 0, Line: "user alice logged in", Unverified: true}
+	require.Equal(t, []*ArchiveMatch{rawMatch}, search("template~user"))
+	require.Equal(t, []*ArchiveMatch{rawMatch}, search(`template="user * in"`))
+	require.Equal(t, []*ArchiveMatch{rawMatch}, search("$0=alice"))
+	require.Equal(t, []*ArchiveMatch{}, search("template~user $0=bob"))
+	require.Equal(t, []*ArchiveMatch{{LineNumber: 1, ClusterId: 2, Line: "disk /dev/sda1 is full", Parameters: []string{}}}, search("template~disk"))
+
+	matches := search("")
+	require.Len(t, matches, 2)
+	require.False(t, matches[0].Unverified)
+}
+
+func TestSearchArchiveSkipsBlocks(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	archive := compressLines(t, drain, []string{
+		"user alice logged in",
+		"user bob logged in",
+		"disk sda is full",
+		"disk sdb is full",
+	}, WithBlockLines(2))
+
+	// corrupts the compressed body of the first block, past the header and the block index
+	offset := len(archiveMagic) + 1 + (1 + len("<*>")) + 1
+	indexLength, n := binary.Uvarint(archive[offset:])
+	offset += n + int(indexLength)
+	bodyLength, n := binary.Uvarint(archive[offset:])
+	offset += n
+	for i := offset; i < offset+int(bodyLength); i++ {
+		archive[i] = 0xff
+	}
+	require.Error(t, Decompress(bytes.NewReader(archive), &bytes.Buffer{}))
+
+	// the first block has no disk template, so its body is never inflated
+	query, err := ParseArchiveQuery("template~disk")
+	require.NoError(t, err)
+	matches, err := SearchArchive(context.Background(), bytes.NewReader(archive), query)
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+	require.Equal(t, int64(2), matches[0].LineNumber)
+	require.Equal(t, "disk sdb is full", matches[1].Line)
+
+	query, err = ParseArchiveQuery("template~user")
+	require.NoError(t, err)
+	_, err = SearchArchive(context.Background(), bytes.NewReader(archive), query)
+	require.Error(t, err)
+}
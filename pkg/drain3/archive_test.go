@@ -0,0 +1,165 @@
+package drain3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func compress(t *testing.T, drain *Drain, input string, options ...compressorOptionFn) []byte {
+	miner := NewTemplateMiner(drain, NewMemoryPersistence())
+	archive := bytes.Buffer{}
+	err := NewCompressor(miner, options...).Compress(context.Background(), strings.NewReader(input), &archive)
+	require.NoError(t, err)
+	return archive.Bytes()
+}
+
+func compressLines(t *testing.T, drain *Drain, lines []string, options ...compressorOptionFn) []byte {
+	return compress(t, drain, strings.Join(lines, "\n")+"\n", options...)
+}
+
+func decompress(t *testing.T, archive []byte) string {
+	decompressed := bytes.Buffer{}
+	require.NoError(t, Decompress(bytes.NewReader(archive), &decompressed))
+	return decompressed.String()
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	newDrains := map[string]func() (*Drain, error){
+		"default": func() (*Drain, error) {
+			return NewDrain()
+		},
+		"extra delimiters": func() (*Drain, error) {
+			return NewDrain(WithExtraDelimiter([]string{"_", ","}))
+		},
+		"variable length": func() (*Drain, error) {
+			return NewDrain(WithVariableLength(3))
+		},
+	}
+
+	for name, newDrain := range newDrains {
+		t.Run(name, func(t *testing.T) {
+			drain, err := newDrain()
+			require.NoError(t, err)
+
+			lines := append([]string{
+				"  leading spaces 1",
+				"trailing spaces 2 \t",
+				"double  space 3",
+				"",
+				"   ",
+			}, kafkaLogs...)
+			// runs of spaces make some lines fall back to being stored as is, which must not change the output
+			separators := append(mixedSeparators(drain.ExtraDelimiters), "  ")
+			lines = append(lines, generateLines(rand.New(rand.NewSource(1)), 300, separators)...)
+			archive := compressLines(t, drain, lines, WithBlockLines(7))
+			require.Equal(t, strings.Join(lines, "\n")+"\n", decompress(t, archive))
+		})
+	}
+}
+
+func TestArchiveRoundTripTerminators(t *testing.T) {
+	longLine := "payload " + strings.Repeat("x", 70000) + " end"
+	input := "user 1 logged in\r\nuser 2 logged in\n\r\n" + longLine + "\r\nlast line\r"
+
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	archive := compress(t, drain, input, WithBlockLines(2))
+	require.Equal(t, input, decompress(t, archive))
+
+	// lines are returned without their terminators
+	reader, err := NewArchiveReader(bytes.NewReader(archive))
+	require.NoError(t, err)
+	block, err := reader.Next()
+	require.NoError(t, err)
+	lines, err := block.Lines()
+	require.NoError(t, err)
+	require.Equal(t, []string{"user 1 logged in", "user 2 logged in"}, lines)
+	require.Equal(t, []string{"\r\n", "\n"}, block.Terminators)
+}
+
+func TestArchiveCompressesDelimitedLines(t *testing.T) {
+	lines := []string{}
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, fmt.Sprintf("user_id=%d,action=login;status=%d,  latency_ms=%d", i, 200+i%3, i%97))
+	}
+	raw := strings.Join(lines, "\n") + "\n"
+
+	drain, err := NewDrain(WithExtraDelimiter([]string{"_", ",", ";", "="}))
+	require.NoError(t, err)
+	archive := compressLines(t, drain, lines)
+	require.Less(t, len(archive), len(raw)/5)
+	require.Equal(t, raw, decompress(t, archive))
+
+	// the lines are rendered from their template and layout rather than stored as is
+	reader, err := NewArchiveReader(bytes.NewReader(archive))
+	require.NoError(t, err)
+	block, err := reader.Next()
+	require.NoError(t, err)
+	require.Empty(t, block.RawLines)
+}
+
+func TestArchiveReaderRejectsOversizedBlock(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	archive := compress(t, drain, "")
+
+	oversized := newBinaryWriter()
+	oversized.writeUvarint(maxArchiveBlockSize + 1)
+	reader, err := NewArchiveReader(bytes.NewReader(append(archive, oversized.buf.Bytes()...)))
+	require.NoError(t, err)
+	_, err = reader.Next()
+	require.ErrorContains(t, err, "exceeds the maximum")
+}
+
+func TestArchiveReader(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	lines := []string{"user 1 logged in", "user 2 logged in", "disk full", "user 3 logged in"}
+	archive := compressLines(t, drain, lines, WithBlockLines(3))
+
+	reader, err := NewArchiveReader(bytes.NewReader(archive))
+	require.NoError(t, err)
+
+	block, err := reader.Next()
+	require.NoError(t, err)
+	blockLines, err := block.Lines()
+	require.NoError(t, err)
+	require.Equal(t, lines[:3], blockLines)
+	require.Len(t, block.Templates, 2)
+	require.Equal(t, "user <*> logged in", block.Templates[0].Template)
+	require.Equal(t, [][]string{{"1", "2"}}, block.Templates[0].Parameters)
+
+	block, err = reader.Next()
+	require.NoError(t, err)
+	blockLines, err = block.Lines()
+	require.NoError(t, err)
+	require.Equal(t, lines[3:], blockLines)
+
+	_, err = reader.Next()
+	require.Equal(t, io.EOF, err)
+
+	_, err = NewArchiveReader(strings.NewReader("not an archive"))
+	require.Error(t, err)
+}
+
+func TestArchiveCompresses(t *testing.T) {
+	lines := []string{}
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, fmt.Sprintf("request %d from 10.0.%d.%d served in %d ms with status %d", i, i%7, i%13, i%97, 200+i%3))
+		lines = append(lines, fmt.Sprintf("cache miss for key user-%d", i*31))
+	}
+	raw := strings.Join(lines, "\n") + "\n"
+
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	archive := compressLines(t, drain, lines)
+	require.Less(t, len(archive), len(raw)/5)
+
+	require.Equal(t, raw, decompress(t, archive))
+}
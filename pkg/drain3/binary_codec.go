@@ -0,0 +1,481 @@
+package drain3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// binary snapshots start with a magic followed by the snapshot version, a table of every interned string
+// and the drain state in which strings are referenced by their index in the table
+var binarySnapshotMagic = []byte("GD3B")
+
+// BinaryCodec is a compact alternative to JSONCodec which interns tree keys and template tokens
+type BinaryCodec struct{}
+
+func NewBinaryCodec() *BinaryCodec {
+	return &BinaryCodec{}
+}
+
+func (c *BinaryCodec) Marshal(drain *Drain) ([]byte, error) {
+	serializable := drain.toSerializable()
+
+	body := newBinaryWriter()
+	body.writeVarint(serializable.LogClusterDepth)
+	body.writeVarint(serializable.MaxNodeDepth)
+	body.writeFloat(serializable.SimTh)
+	body.writeVarint(serializable.MaxChildren)
+	body.writeVarint(int64(serializable.MaxClusters))
+	body.writeStrings(serializable.ExtraDelimiters)
+	body.writeString(serializable.ParamStr)
+	body.writeBool(serializable.ParametrizeNumericTokens)
+	body.writeBool(serializable.RebuildTreeOnLoad)
+	body.writeBool(serializable.KeyValueTokens)
+	body.writeBool(serializable.VariableLength)
+	body.writeString(serializable.VariableParamStr)
+	body.writeVarint(serializable.MaxLengthDelta)
+	body.writeVarint(int64(serializable.MaxClusterSamples))
+	body.writeVarint(serializable.ClustersCounter)
+
+	body.writeUvarint(uint64(len(serializable.Clusters)))
+	for _, cluster := range serializable.Clusters {
+		body.writeVarint(cluster.ClusterId)
+		body.writeVarint(cluster.Size)
+		body.writeStrings(cluster.LogTemplateTokens)
+		body.writeTime(cluster.LastAccessTime)
+		body.writeStrings(cluster.Samples)
+		body.writeParamSlots(cluster.ParamSlots)
+		body.writeString(cluster.Partition)
+	}
+
+	body.writeBool(serializable.RootNode != nil)
+	if serializable.RootNode != nil {
+		body.writeNode(serializable.RootNode)
+	}
+
+	leaves := make([]string, 0, len(serializable.LeafSimStats))
+	for leaf := range serializable.LeafSimStats {
+		leaves = append(leaves, leaf)
+	}
+	sort.Strings(leaves)
+	body.writeUvarint(uint64(len(leaves)))
+	for _, leaf := range leaves {
+		body.writeString(leaf)
+		body.writeVarint(serializable.LeafSimStats[leaf].Count)
+		body.writeFloat(serializable.LeafSimStats[leaf].Sum)
+	}
+
+	header := newBinaryWriter()
+	header.buf.Write(binarySnapshotMagic)
+	header.writeUvarint(uint64(serializable.Version))
+	header.writeTable(body.table)
+	header.buf.Write(body.buf.Bytes())
+
+	return header.buf.Bytes(), nil
+}
+
+func (c *BinaryCodec) Unmarshal(state []byte) (*Drain, error) {
+	if !bytes.HasPrefix(state, binarySnapshotMagic) {
+		return nil, errors.New("state is not a binary snapshot")
+	}
+
+	reader := &binaryReader{data: state, pos: len(binarySnapshotMagic)}
+
+	version := int(reader.readUvarint())
+	if reader.err == nil && version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported binary snapshot version %d", version)
+	}
+
+	reader.readTable()
+
+	serializable := &SerializableDrain{Version: version}
+	serializable.LogClusterDepth = reader.readVarint()
+	serializable.MaxNodeDepth = reader.readVarint()
+	serializable.SimTh = reader.readFloat()
+	serializable.MaxChildren = reader.readVarint()
+	serializable.MaxClusters = int(reader.readVarint())
+	serializable.ExtraDelimiters = reader.readStrings()
+	serializable.ParamStr = reader.readString()
+	serializable.ParametrizeNumericTokens = reader.readBool()
+	serializable.RebuildTreeOnLoad = reader.readBool()
+	serializable.KeyValueTokens = reader.readBool()
+	serializable.VariableLength = reader.readBool()
+	serializable.VariableParamStr = reader.readString()
+	serializable.MaxLengthDelta = reader.readVarint()
+	serializable.MaxClusterSamples = int(reader.readVarint())
+	serializable.ClustersCounter = reader.readVarint()
+
+	clusterCount := reader.readUvarint()
+	serializable.Clusters = []*LogCluster{}
+	for i := uint64(0); i < clusterCount && reader.err == nil; i++ {
+		cluster := &LogCluster{}
+		cluster.ClusterId = reader.readVarint()
+		cluster.Size = reader.readVarint()
+		cluster.LogTemplateTokens = reader.readStrings()
+		cluster.LastAccessTime = reader.readTime()
+		// clusters without samples keep a nil slice as they do in json snapshots
+		if samples := reader.readStrings(); len(samples) > 0 {
+			cluster.Samples = samples
+		}
+		cluster.ParamSlots = reader.readParamSlots()
+		cluster.Partition = reader.readString()
+		serializable.Clusters = append(serializable.Clusters, cluster)
+	}
+
+	if reader.readBool() {
+		serializable.RootNode = reader.readNode()
+	}
+
+	// a snapshot without learned statistics has none, as json snapshots do
+	leafCount := reader.readUvarint()
+	for i := uint64(0); i < leafCount && reader.err == nil; i++ {
+		if serializable.LeafSimStats == nil {
+			serializable.LeafSimStats = map[string]*LeafSimStats{}
+		}
+		leaf := reader.readString()
+		serializable.LeafSimStats[leaf] = &LeafSimStats{Count: reader.readVarint(), Sum: reader.readFloat()}
+	}
+
+	if reader.err != nil {
+		return nil, fmt.Errorf("failed to read binary snapshot: %w", reader.err)
+	}
+
+	drain := &Drain{}
+	if err := drain.fromSerializable(serializable); err != nil {
+		return nil, err
+	}
+
+	return drain, nil
+}
+
+type binaryWriter struct {
+	buf     bytes.Buffer
+	table   []string
+	indexes map[string]uint64
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func newBinaryWriter() *binaryWriter {
+	return &binaryWriter{indexes: map[string]uint64{}}
+}
+
+func (w *binaryWriter) writeUvarint(value uint64) {
+	n := binary.PutUvarint(w.scratch[:], value)
+	w.buf.Write(w.scratch[:n])
+}
+
+func (w *binaryWriter) writeVarint(value int64) {
+	n := binary.PutVarint(w.scratch[:], value)
+	w.buf.Write(w.scratch[:n])
+}
+
+func (w *binaryWriter) writeFloat(value float64) {
+	binary.LittleEndian.PutUint64(w.scratch[:8], math.Float64bits(value))
+	w.buf.Write(w.scratch[:8])
+}
+
+func (w *binaryWriter) writeBool(value bool) {
+	if value {
+		w.buf.WriteByte(1)
+	} else {
+		w.buf.WriteByte(0)
+	}
+}
+
+// writeTime writes unix nanoseconds, with zero for the zero time
+func (w *binaryWriter) writeTime(value time.Time) {
+	if value.IsZero() {
+		w.writeVarint(0)
+	} else {
+		w.writeVarint(value.UnixNano())
+	}
+}
+
+// writeTable writes the interned strings another writer referenced
+func (w *binaryWriter) writeTable(table []string) {
+	w.writeUvarint(uint64(len(table)))
+	for _, str := range table {
+		w.writeUvarint(uint64(len(str)))
+		w.buf.WriteString(str)
+	}
+}
+
+// writeString writes the index of an interned string
+func (w *binaryWriter) writeString(str string) {
+	index, exist := w.indexes[str]
+	if !exist {
+		index = uint64(len(w.table))
+		w.indexes[str] = index
+		w.table = append(w.table, str)
+	}
+	w.writeUvarint(index)
+}
+
+func (w *binaryWriter) writeStrings(strs []string) {
+	w.writeUvarint(uint64(len(strs)))
+	for _, str := range strs {
+		w.writeString(str)
+	}
+}
+
+func (w *binaryWriter) writeParamSlots(slots map[int]*ParamSlot) {
+	tokenIndexes := make([]int, 0, len(slots))
+	for tokenIndex := range slots {
+		tokenIndexes = append(tokenIndexes, tokenIndex)
+	}
+	sort.Ints(tokenIndexes)
+
+	w.writeUvarint(uint64(len(tokenIndexes)))
+	for _, tokenIndex := range tokenIndexes {
+		typeCounts := slots[tokenIndex].TypeCounts
+		paramTypes := make([]ParamType, 0, len(typeCounts))
+		for paramType := range typeCounts {
+			paramTypes = append(paramTypes, paramType)
+		}
+		sort.Slice(paramTypes, func(i, j int) bool { return paramTypes[i] < paramTypes[j] })
+
+		w.writeVarint(int64(tokenIndex))
+		w.writeUvarint(uint64(len(paramTypes)))
+		for _, paramType := range paramTypes {
+			w.writeString(paramType.String())
+			w.writeVarint(typeCounts[paramType])
+		}
+
+		w.writeParamSlotStats(slots[tokenIndex])
+	}
+}
+
+func (w *binaryWriter) writeParamSlotStats(slot *ParamSlot) {
+	w.writeBool(slot.Cardinality != nil)
+	if slot.Cardinality != nil {
+		w.writeUvarint(uint64(len(slot.Cardinality.Registers)))
+		w.buf.Write(slot.Cardinality.Registers)
+	}
+
+	w.writeBool(slot.TopValues != nil)
+	if slot.TopValues != nil {
+		w.writeVarint(int64(slot.TopValues.Capacity))
+		w.writeUvarint(uint64(len(slot.TopValues.Counters)))
+		for _, counter := range slot.TopValues.Counters {
+			w.writeString(counter.Value)
+			w.writeVarint(counter.Count)
+			w.writeVarint(counter.Error)
+		}
+	}
+
+	w.writeBool(slot.Numeric != nil)
+	if slot.Numeric != nil {
+		w.writeVarint(slot.Numeric.Count)
+		w.writeFloat(slot.Numeric.Min)
+		w.writeFloat(slot.Numeric.Max)
+		w.writeFloat(slot.Numeric.Sum)
+	}
+}
+
+func (w *binaryWriter) writeNode(node *Node) {
+	if node == nil {
+		node = NewNode()
+	}
+
+	w.writeUvarint(uint64(len(node.ClusterIds)))
+	for _, clusterId := range node.ClusterIds {
+		w.writeVarint(clusterId)
+	}
+
+	// sort keys so that the same tree always produces the same bytes
+	keys := make([]string, 0, len(node.KeyToChildNode))
+	for key := range node.KeyToChildNode {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w.writeUvarint(uint64(len(keys)))
+	for _, key := range keys {
+		w.writeString(key)
+		w.writeNode(node.KeyToChildNode[key])
+	}
+}
+
+// binaryReader keeps the first error it meets, after which every read returns a zero value
+type binaryReader struct {
+	data  []byte
+	pos   int
+	table []string
+	err   error
+}
+
+func (r *binaryReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *binaryReader) readUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+
+	value, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.fail(fmt.Errorf("invalid uvarint at offset %d", r.pos))
+		return 0
+	}
+	r.pos += n
+	return value
+}
+
+func (r *binaryReader) readVarint() int64 {
+	if r.err != nil {
+		return 0
+	}
+
+	value, n := binary.Varint(r.data[r.pos:])
+	if n <= 0 {
+		r.fail(fmt.Errorf("invalid varint at offset %d", r.pos))
+		return 0
+	}
+	r.pos += n
+	return value
+}
+
+func (r *binaryReader) readBytes(length uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if length > uint64(len(r.data)-r.pos) {
+		r.fail(fmt.Errorf("unexpected end of data at offset %d", r.pos))
+		return nil
+	}
+
+	value := r.data[r.pos : r.pos+int(length)]
+	r.pos += int(length)
+	return value
+}
+
+func (r *binaryReader) readFloat() float64 {
+	value := r.readBytes(8)
+	if value == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(value))
+}
+
+func (r *binaryReader) readBool() bool {
+	value := r.readBytes(1)
+	return value != nil && value[0] == 1
+}
+
+func (r *binaryReader) readTime() time.Time {
+	value := r.readVarint()
+	if value == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, value).UTC()
+}
+
+func (r *binaryReader) readString() string {
+	index := r.readUvarint()
+	if r.err != nil {
+		return ""
+	}
+
+	if index >= uint64(len(r.table)) {
+		r.fail(fmt.Errorf("string index %d out of range", index))
+		return ""
+	}
+	return r.table[index]
+}
+
+func (r *binaryReader) readTable() {
+	tableSize := r.readUvarint()
+	for i := uint64(0); i < tableSize && r.err == nil; i++ {
+		r.table = append(r.table, string(r.readBytes(r.readUvarint())))
+	}
+}
+
+func (r *binaryReader) readStrings() []string {
+	count := r.readUvarint()
+	strs := []string{}
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		strs = append(strs, r.readString())
+	}
+	return strs
+}
+
+// readParamSlots returns nil when there is no slot, as json snapshots do
+func (r *binaryReader) readParamSlots() map[int]*ParamSlot {
+	slotCount := r.readUvarint()
+	if slotCount == 0 {
+		return nil
+	}
+
+	slots := map[int]*ParamSlot{}
+	for i := uint64(0); i < slotCount && r.err == nil; i++ {
+		tokenIndex := int(r.readVarint())
+		slot := &ParamSlot{TypeCounts: map[ParamType]int64{}}
+
+		typeCount := r.readUvarint()
+		for j := uint64(0); j < typeCount && r.err == nil; j++ {
+			paramType, err := parseParamType(r.readString())
+			if err != nil {
+				r.fail(err)
+			}
+			slot.TypeCounts[paramType] = r.readVarint()
+		}
+		r.readParamSlotStats(slot)
+		slots[tokenIndex] = slot
+	}
+	return slots
+}
+
+func (r *binaryReader) readParamSlotStats(slot *ParamSlot) {
+	if r.readBool() {
+		registers := r.readBytes(r.readUvarint())
+		if r.err == nil && len(registers) != hyperLogLogRegisterCount {
+			r.fail(fmt.Errorf("hyperloglog has %d registers instead of %d", len(registers), hyperLogLogRegisterCount))
+		}
+		slot.Cardinality = &HyperLogLog{Registers: append([]byte{}, registers...)}
+	}
+
+	if r.readBool() {
+		slot.TopValues = NewSpaceSaving(int(r.readVarint()))
+		counterCount := r.readUvarint()
+		for i := uint64(0); i < counterCount && r.err == nil; i++ {
+			counter := &ValueCount{}
+			counter.Value = r.readString()
+			counter.Count = r.readVarint()
+			counter.Error = r.readVarint()
+			slot.TopValues.Counters = append(slot.TopValues.Counters, counter)
+		}
+	}
+
+	if r.readBool() {
+		slot.Numeric = &NumericStats{}
+		slot.Numeric.Count = r.readVarint()
+		slot.Numeric.Min = r.readFloat()
+		slot.Numeric.Max = r.readFloat()
+		slot.Numeric.Sum = r.readFloat()
+	}
+}
+
+func (r *binaryReader) readNode() *Node {
+	node := NewNode()
+
+	clusterIdCount := r.readUvarint()
+	for i := uint64(0); i < clusterIdCount && r.err == nil; i++ {
+		node.ClusterIds = append(node.ClusterIds, r.readVarint())
+	}
+
+	childCount := r.readUvarint()
+	for i := uint64(0); i < childCount && r.err == nil; i++ {
+		key := r.readString()
+		node.KeyToChildNode[key] = r.readNode()
+	}
+
+	return node
+}
@@ -0,0 +1,237 @@
+package drain3
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// MergeClusters merges the source cluster into the target cluster, whose template becomes the template matching both.
+// the source cluster is removed and the target cluster moves in the prefix tree if its template changed
+func (d *Drain) MergeClusters(targetId, sourceId int64) (*LogCluster, error) {
+	if targetId == sourceId {
+		return nil, errors.New("cannot merge a cluster into itself")
+	}
+
+	target, exist := d.IdToCluster.Peek(targetId)
+	if !exist {
+		return nil, fmt.Errorf("cluster %d not found", targetId)
+	}
+	source, exist := d.IdToCluster.Peek(sourceId)
+	if !exist {
+		return nil, fmt.Errorf("cluster %d not found", sourceId)
+	} else if target.Partition != source.Partition {
+		return nil, errors.New("cannot merge clusters of different partitions")
+	}
+
+	templateTokens, err := d.mergeTemplates(target.LogTemplateTokens, source.LogTemplateTokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge templates: %w", err)
+	}
+
+	d.removeFromPrefixTree(source)
+	d.IdToCluster.Remove(source.ClusterId)
+
+	d.removeFromPrefixTree(target)
+	previousLength := len(target.LogTemplateTokens)
+	target.LogTemplateTokens = templateTokens
+	d.retainParamSlots(target, previousLength)
+	d.addSeqToPrefixTree(d.RootNode, target)
+	d.trackVariableParam(target)
+
+	// slots are keyed by token index, so those of a template of another length cannot be merged
+	if len(source.LogTemplateTokens) == len(templateTokens) {
+		target.ParamSlots = mergeParamSlots(target.ParamSlots, source.ParamSlots)
+	}
+
+	target.Size += source.Size
+	for _, sample := range source.Samples {
+		target.addSample(sample, d.MaxClusterSamples)
+	}
+	if source.LastAccessTime.After(target.LastAccessTime) {
+		target.LastAccessTime = source.LastAccessTime
+	}
+
+	return target, nil
+}
+
+// MergeSimilarClusters merges every pair of clusters whose templates have at least the given similarity,
+// clusters are merged into the one of the lowest id. it returns the ids of the merged clusters mapped to the id they were merged into
+func (d *Drain) MergeSimilarClusters(simTh float64) (map[int64]int64, error) {
+	clusterIds := d.IdToCluster.Keys()
+	sort.Slice(clusterIds, func(i, j int) bool { return clusterIds[i] < clusterIds[j] })
+
+	mergedClusterIds := map[int64]int64{}
+	for i, targetId := range clusterIds {
+		if _, merged := mergedClusterIds[targetId]; merged {
+			continue
+		}
+
+		for _, sourceId := range clusterIds[i+1:] {
+			if _, merged := mergedClusterIds[sourceId]; merged {
+				continue
+			}
+
+			// the target template is looked up again as every merge generalizes it
+			target, exist := d.IdToCluster.Peek(targetId)
+			if !exist {
+				break
+			}
+			source, exist := d.IdToCluster.Peek(sourceId)
+			if !exist || source.Partition != target.Partition {
+				continue
+			}
+
+			sim, err := d.getTemplateSimilarity(target.LogTemplateTokens, source.LogTemplateTokens)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get template similarity: %w", err)
+			} else if sim < simTh {
+				continue
+			}
+
+			if _, err := d.MergeClusters(targetId, sourceId); err != nil {
+				return nil, fmt.Errorf("failed to merge cluster %d into %d: %w", sourceId, targetId, err)
+			}
+			mergedClusterIds[sourceId] = targetId
+		}
+	}
+
+	return mergedClusterIds, nil
+}
+
+// SplitCluster splits a cluster by the token its samples have at the given position, one cluster per distinct token.
+// the templates of the new clusters are built from the samples only, so drain must keep samples (WithMaxClusterSamples).
+// samples are distinct recent messages, so the sizes of the new clusters are only an estimate sharing the size in proportion
+// to the distinct samples of each group, whatever the number of messages each sample stands for.
+// the largest group keeps the id of the cluster and is returned first
+func (d *Drain) SplitCluster(clusterId int64, position int) ([]*LogCluster, error) {
+	cluster, exist := d.IdToCluster.Peek(clusterId)
+	if !exist {
+		return nil, fmt.Errorf("cluster %d not found", clusterId)
+	} else if position < 0 || position >= len(cluster.LogTemplateTokens) {
+		return nil, fmt.Errorf("position %d out of the %d tokens of cluster %d", position, len(cluster.LogTemplateTokens), clusterId)
+	} else if d.hasVariableParam(cluster.LogTemplateTokens) {
+		return nil, errors.New("cannot split a template having variable parameters")
+	} else if len(cluster.Samples) == 0 {
+		return nil, fmt.Errorf("cluster %d has no samples", clusterId)
+	}
+
+	type sampleGroup struct {
+		templateTokens []string
+		samples        []string
+	}
+
+	groups := []*sampleGroup{}
+	tokenToGroup := map[string]*sampleGroup{}
+	sampleCount := 0
+	for _, sample := range cluster.Samples {
+		tokens := d.getContentAsTokens(sample)
+		if len(tokens) != len(cluster.LogTemplateTokens) {
+			continue
+		}
+		sampleCount++
+
+		group, exist := tokenToGroup[tokens[position]]
+		if !exist {
+			group = &sampleGroup{templateTokens: tokens}
+			tokenToGroup[tokens[position]] = group
+			groups = append(groups, group)
+		}
+
+		templateTokens, err := d.createTemplate(tokens, group.templateTokens)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create template: %w", err)
+		}
+		group.templateTokens = templateTokens
+		group.samples = append(group.samples, sample)
+	}
+
+	if len(groups) < 2 {
+		return nil, fmt.Errorf("samples of cluster %d have less than two distinct tokens at position %d", clusterId, position)
+	} else if len(groups) > d.MaxClusters {
+		return nil, fmt.Errorf("cannot split cluster %d into %d clusters, drain holds at most %d", clusterId, len(groups), d.MaxClusters)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].samples) > len(groups[j].samples) })
+
+	// the statistics of the parameters cannot be divided between the groups, every cluster learns them again
+	cluster.ParamSlots = nil
+
+	clusters := []*LogCluster{}
+	remainingSize := cluster.Size
+	for i, group := range groups {
+		groupCluster := cluster
+		if i == 0 {
+			d.removeFromPrefixTree(cluster)
+		} else {
+			d.ClustersCounter++
+			groupCluster = NewLogCluster(d.ClustersCounter, nil)
+			groupCluster.LastAccessTime = cluster.LastAccessTime
+			groupCluster.Partition = cluster.Partition
+			groupCluster.Size = cluster.Size * int64(len(group.samples)) / int64(sampleCount)
+			remainingSize -= groupCluster.Size
+		}
+
+		groupCluster.LogTemplateTokens = group.templateTokens
+		groupCluster.Samples = group.samples
+		clusters = append(clusters, groupCluster)
+	}
+	cluster.Size = remainingSize
+
+	// the cluster may be the least recently used one, it is added again with the groups so that they cannot evict it
+	d.IdToCluster.Remove(cluster.ClusterId)
+	for _, groupCluster := range clusters {
+		d.IdToCluster.Add(groupCluster.ClusterId, groupCluster)
+		d.addSeqToPrefixTree(d.RootNode, groupCluster)
+		d.trackVariableParam(groupCluster)
+	}
+
+	return clusters, nil
+}
+
+// mergeTemplates returns the template matching both templates
+func (d *Drain) mergeTemplates(template1, template2 []string) ([]string, error) {
+	if len(template1) != len(template2) {
+		if !d.VariableLength {
+			return nil, fmt.Errorf("cannot merge templates of %d and %d tokens", len(template1), len(template2))
+		}
+
+		return d.createAlignedTemplate(template1, template2, d.alignTokens(template1, template2, true)), nil
+	}
+
+	templateTokens, err := d.createTemplate(template2, template1)
+	if err != nil {
+		return nil, err
+	}
+
+	// createTemplate only keeps the variable parameters of its second template
+	for i, token := range template2 {
+		if d.isVariableParam(token) {
+			templateTokens[i] = d.VariableParamStr
+		}
+	}
+
+	return templateTokens, nil
+}
+
+// getTemplateSimilarity returns the share of tokens of two templates which match each other, parameters matching any token
+func (d *Drain) getTemplateSimilarity(template1, template2 []string) (float64, error) {
+	if len(template1) != len(template2) {
+		if !d.VariableLength {
+			return 0, nil
+		}
+
+		return max(d.alignTokens(template1, template2, true).sim, d.alignTokens(template2, template1, true).sim), nil
+	}
+
+	sim1, _, err := d.getSeqDistance(template1, template2, true)
+	if err != nil {
+		return 0, err
+	}
+	sim2, _, err := d.getSeqDistance(template2, template1, true)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(sim1, sim2), nil
+}
@@ -0,0 +1,114 @@
+package drain3
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMergeClusters(t *testing.T) {
+	drain, err := NewDrain(WithSimTh(0.7), WithMaxClusterSamples(10))
+	require.NoError(t, err)
+
+	for _, log := range []string{"alpha beta gamma delta", "alpha beta zeta eta", "disk full"} {
+		_, _, err := drain.AddLogMessage(log)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, drain.IdToCluster.Len())
+
+	_, err = drain.MergeClusters(1, 3)
+	require.Error(t, err)
+
+	merged, err := drain.MergeSimilarClusters(0.5)
+	require.NoError(t, err)
+	require.Equal(t, map[int64]int64{2: 1}, merged)
+
+	cluster, exist := drain.IdToCluster.Peek(1)
+	require.True(t, exist)
+	require.Equal(t, "alpha beta <*> <*>", cluster.GetTemplate())
+	require.Equal(t, int64(2), cluster.Size)
+	require.Equal(t, []string{"alpha beta gamma delta", "alpha beta zeta eta"}, cluster.Samples)
+	require.True(t, drain.CheckConsistency().IsConsistent())
+
+	matched, err := drain.Match("alpha beta zeta eta", SearchStrategyNever)
+	require.NoError(t, err)
+	require.Equal(t, cluster, matched)
+}
+
+func TestSplitCluster(t *testing.T) {
+	drain, err := NewDrain(WithMaxClusterSamples(10))
+	require.NoError(t, err)
+
+	for _, log := range []string{
+		"service api started on port 80",
+		"service db started on port 5432",
+		"service api started on port 81",
+	} {
+		_, _, err := drain.AddLogMessage(log)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, drain.IdToCluster.Len())
+
+	_, err = drain.SplitCluster(1, 2)
+	require.ErrorContains(t, err, "less than two distinct tokens")
+
+	clusters, err := drain.SplitCluster(1, 1)
+	require.NoError(t, err)
+	require.Len(t, clusters, 2)
+	require.Equal(t, int64(1), clusters[0].ClusterId)
+	require.Equal(t, "service api started on port <*>", clusters[0].GetTemplate())
+	require.Equal(t, int64(2), clusters[0].Size)
+	require.Equal(t, int64(2), clusters[1].ClusterId)
+	require.Equal(t, "service db started on port 5432", clusters[1].GetTemplate())
+	require.Equal(t, int64(1), clusters[1].Size)
+	require.True(t, drain.CheckConsistency().IsConsistent())
+
+	matched, err := drain.Match("service db started on port 5432", SearchStrategyNever)
+	require.NoError(t, err)
+	require.Equal(t, clusters[1], matched)
+}
+
+func TestSplitClusterWithoutSamples(t *testing.T) {
+	drain, err := NewDrain(WithMaxClusterSamples(0))
+	require.NoError(t, err)
+
+	for _, log := range []string{"service api started", "service db started"} {
+		_, _, err := drain.AddLogMessage(log)
+		require.NoError(t, err)
+	}
+
+	_, err = drain.SplitCluster(1, 1)
+	require.EqualError(t, err, "cluster 1 has no samples")
+}
+
+func TestSplitClusterAtMaxClusters(t *testing.T) {
+	drain, err := NewDrain(WithMaxCluster(2), WithMaxClusterSamples(10))
+	require.NoError(t, err)
+
+	// the cluster to split is the least recently used one
+	for _, log := range []string{"user alice logged in", "user bob logged in", "disk full"} {
+		_, _, err := drain.AddLogMessage(log)
+		require.NoError(t, err)
+	}
+	require.Equal(t, []int64{1, 2}, drain.IdToCluster.Keys())
+
+	clusters, err := drain.SplitCluster(1, 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), clusters[0].ClusterId)
+	require.Equal(t, int64(3), clusters[1].ClusterId)
+	require.Equal(t, []int64{1, 3}, drain.IdToCluster.Keys())
+
+	// only the cluster evicted by the new one is left in the tree
+	report := drain.CheckConsistency()
+	require.Equal(t, []int64{2}, report.DanglingClusterIds)
+	require.Empty(t, report.MissingClusterIds)
+
+	// a split never evicts the clusters it creates
+	for _, log := range []string{"user carol logged in", "user dave logged in"} {
+		_, _, err := drain.AddLogMessage(log)
+		require.NoError(t, err)
+	}
+	cluster, err := drain.Match("user carol logged in", SearchStrategyNever)
+	require.NoError(t, err)
+	_, err = drain.SplitCluster(cluster.ClusterId, 1)
+	require.ErrorContains(t, err, "drain holds at most 2")
+}
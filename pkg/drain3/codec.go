@@ -0,0 +1,46 @@
+package drain3
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// SnapshotCodec encodes the state of a Drain for persistence
+type SnapshotCodec interface {
+	Marshal(drain *Drain) ([]byte, error)
+	Unmarshal(state []byte) (*Drain, error)
+}
+
+type JSONCodec struct{}
+
+func NewJSONCodec() *JSONCodec {
+	return &JSONCodec{}
+}
+
+func (c *JSONCodec) Marshal(drain *Drain) ([]byte, error) {
+	state, err := json.Marshal(drain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal json: %w", err)
+	}
+
+	return state, nil
+}
+
+func (c *JSONCodec) Unmarshal(state []byte) (*Drain, error) {
+	var drain *Drain
+	if err := json.Unmarshal(state, &drain); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal json: %w", err)
+	}
+
+	return drain, nil
+}
+
+// detectSnapshotCodec returns the codec which wrote the given state
+func detectSnapshotCodec(state []byte) SnapshotCodec {
+	if bytes.HasPrefix(state, binarySnapshotMagic) {
+		return NewBinaryCodec()
+	}
+
+	return NewJSONCodec()
+}
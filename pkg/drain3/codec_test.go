@@ -0,0 +1,93 @@
+package drain3
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotCodecs(t *testing.T) {
+	drain := newSyntheticDrain(t, 2000)
+
+	for _, codec := range []SnapshotCodec{NewJSONCodec(), NewBinaryCodec()} {
+		t.Run(fmt.Sprintf("%T", codec), func(t *testing.T) {
+			persistence := NewMemoryPersistence()
+			miner := NewTemplateMiner(drain, persistence, WithSnapshotCodec(codec))
+			require.NoError(t, miner.SaveState(context.Background()))
+
+			// load auto-detects the codec regardless of the one configured on the miner
+			loader := NewTemplateMiner(nil, persistence)
+			require.NoError(t, loader.LoadState(context.Background()))
+
+			loaded := loader.drain
+			require.Equal(t, drain.ClustersCounter, loaded.ClustersCounter)
+			require.Equal(t, drain.ExtraDelimiters, loaded.ExtraDelimiters)
+			require.Equal(t, drain.SimTh, loaded.SimTh)
+			require.Equal(t, drain.RootNode, loaded.RootNode)
+			require.Equal(t, drain.IdToCluster.Keys(), loaded.IdToCluster.Keys())
+			require.Equal(t, drain.IdToCluster.Values(), loaded.IdToCluster.Values())
+		})
+	}
+}
+
+func BenchmarkSnapshotCodecs(b *testing.B) {
+	drain := newSyntheticDrain(b, 5000)
+
+	for _, codec := range []SnapshotCodec{NewJSONCodec(), NewBinaryCodec()} {
+		state, err := codec.Marshal(drain)
+		require.NoError(b, err)
+
+		b.Run(fmt.Sprintf("%T/Marshal", codec), func(b *testing.B) {
+			b.ReportMetric(float64(len(state)), "state-bytes")
+			for i := 0; i < b.N; i++ {
+				if _, err := codec.Marshal(drain); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("%T/Unmarshal", codec), func(b *testing.B) {
+			b.ReportMetric(float64(len(state)), "state-bytes")
+			for i := 0; i < b.N; i++ {
+				if _, err := codec.Unmarshal(state); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// newSyntheticDrain mines messages built from a small vocabulary so that clusters share many tokens, like real logs do
+func newSyntheticDrain(t testing.TB, messageCount int, options ...optionFn) *Drain {
+	drain, err := NewDrain(append([]optionFn{WithMaxCluster(messageCount), WithExtraDelimiter([]string{"_"})}, options...)...)
+	require.NoError(t, err)
+
+	random := rand.New(rand.NewSource(42))
+	vocabulary := []string{}
+	for i := 0; i < 300; i++ {
+		word := []byte{}
+		for j := 0; j < 3+random.Intn(8); j++ {
+			word = append(word, byte('a'+random.Intn(26)))
+		}
+		vocabulary = append(vocabulary, string(word))
+	}
+
+	for i := 0; i < messageCount; i++ {
+		tokens := []string{}
+		for j := 0; j < 4+random.Intn(12); j++ {
+			if random.Intn(4) == 0 {
+				tokens = append(tokens, fmt.Sprint(random.Intn(100000)))
+			} else {
+				tokens = append(tokens, vocabulary[random.Intn(len(vocabulary))])
+			}
+		}
+
+		_, _, err := drain.AddLogMessage(strings.Join(tokens, " "))
+		require.NoError(t, err)
+	}
+
+	return drain
+}
@@ -0,0 +1,71 @@
+package drain3
+
+import (
+	"fmt"
+	"sort"
+)
+
+type ConsistencyReport struct {
+	// cluster ids referenced by tree nodes but missing in IdToCluster.
+	// drain removes ids of evicted clusters lazily, so these are expected once MaxClusters was reached
+	DanglingClusterIds []int64
+	// clusters in IdToCluster which no tree node references, those can never be matched again
+	MissingClusterIds []int64
+	// paths of tree nodes having more children than MaxChildren allows
+	MaxChildrenViolations []string
+}
+
+func (r *ConsistencyReport) IsConsistent() bool {
+	return len(r.DanglingClusterIds) == 0 && len(r.MissingClusterIds) == 0 && len(r.MaxChildrenViolations) == 0
+}
+
+// CheckConsistency compares the prefix tree with the cluster cache
+func (d *Drain) CheckConsistency() *ConsistencyReport {
+	report := &ConsistencyReport{
+		DanglingClusterIds:    []int64{},
+		MissingClusterIds:     []int64{},
+		MaxChildrenViolations: []string{},
+	}
+
+	referencedIds := map[int64]bool{}
+
+	var checkNode func(path string, node *Node, depth int)
+	checkNode = func(path string, node *Node, depth int) {
+		for _, clusterId := range node.ClusterIds {
+			if !referencedIds[clusterId] && !d.IdToCluster.Contains(clusterId) {
+				report.DanglingClusterIds = append(report.DanglingClusterIds, clusterId)
+			}
+			referencedIds[clusterId] = true
+		}
+
+		// children of the root are keyed by token count and not limited by MaxChildren
+		if depth > 0 && int64(len(node.KeyToChildNode)) > d.MaxChildren {
+			report.MaxChildrenViolations = append(report.MaxChildrenViolations, path)
+		}
+
+		for token, child := range node.KeyToChildNode {
+			childPath := fmt.Sprintf("%s/%q", path, token)
+			if depth == 0 {
+				childPath = fmt.Sprintf("%s/<L=%s>", path, token)
+			}
+			checkNode(childPath, child, depth+1)
+		}
+	}
+	checkNode("root", d.RootNode, 0)
+
+	for _, clusterId := range d.IdToCluster.Keys() {
+		if !referencedIds[clusterId] {
+			report.MissingClusterIds = append(report.MissingClusterIds, clusterId)
+		}
+	}
+
+	sort.Slice(report.DanglingClusterIds, func(i, j int) bool {
+		return report.DanglingClusterIds[i] < report.DanglingClusterIds[j]
+	})
+	sort.Slice(report.MissingClusterIds, func(i, j int) bool {
+		return report.MissingClusterIds[i] < report.MissingClusterIds[j]
+	})
+	sort.Strings(report.MaxChildrenViolations)
+
+	return report
+}
@@ -0,0 +1,93 @@
+package drain3
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRebuildTreeOnLoad(t *testing.T) {
+	drain, err := NewDrain(WithRebuildTreeOnLoad())
+	require.NoError(t, err)
+
+	for i := 0; i < 50; i++ {
+		_, _, err := drain.AddLogMessage(fmt.Sprintf("request %d from client-%c served", i, 'a'+i%5))
+		require.NoError(t, err)
+		_, _, err = drain.AddLogMessage(fmt.Sprintf("worker %c finished job", 'a'+i%26))
+		require.NoError(t, err)
+	}
+
+	state, err := json.Marshal(drain)
+	require.NoError(t, err)
+
+	var serializable SerializableDrain
+	require.NoError(t, json.Unmarshal(state, &serializable))
+	require.Nil(t, serializable.RootNode)
+
+	var loaded *Drain
+	require.NoError(t, json.Unmarshal(state, &loaded))
+	require.Equal(t, drain.RootNode, loaded.RootNode)
+	require.True(t, loaded.CheckConsistency().IsConsistent())
+}
+
+func TestRebuiltTreeMatchesLikeOriginal(t *testing.T) {
+	drain, err := NewDrain(WithRebuildTreeOnLoad())
+	require.NoError(t, err)
+
+	messages := append([]string{}, kafkaLogs...)
+	for i := 0; i < 20; i++ {
+		messages = append(messages, fmt.Sprintf("request %d from client-%c served in %dms", i, 'a'+i%3, i*7))
+	}
+	for _, message := range messages {
+		_, _, err := drain.AddLogMessage(message)
+		require.NoError(t, err)
+	}
+
+	state, err := json.Marshal(drain)
+	require.NoError(t, err)
+	var loaded *Drain
+	require.NoError(t, json.Unmarshal(state, &loaded))
+
+	messages = append(messages, "request 99 from client-z served in 1ms", "unseen message")
+	for _, message := range messages {
+		expected, err := drain.Match(message, SearchStrategyNever)
+		require.NoError(t, err)
+		actual, err := loaded.Match(message, SearchStrategyNever)
+		require.NoError(t, err)
+
+		if expected == nil {
+			require.Nil(t, actual, message)
+		} else {
+			require.NotNil(t, actual, message)
+			require.Equal(t, expected.ClusterId, actual.ClusterId, message)
+		}
+	}
+}
+
+func TestCheckConsistency(t *testing.T) {
+	drain, err := NewDrain(WithMaxCluster(2), WithMaxChildren(3))
+	require.NoError(t, err)
+
+	for _, log := range []string{"alpha one", "beta two", "gamma three"} {
+		_, _, err := drain.AddLogMessage(log)
+		require.NoError(t, err)
+	}
+
+	// the first cluster was evicted but its id stays in the tree until its leaf receives a new cluster
+	report := drain.CheckConsistency()
+	require.Equal(t, []int64{1}, report.DanglingClusterIds)
+	require.Empty(t, report.MissingClusterIds)
+	require.Empty(t, report.MaxChildrenViolations)
+
+	drain.RootNode.KeyToChildNode["2"].ClusterIds = []int64{}
+	drain.RootNode.KeyToChildNode["2"].KeyToChildNode = map[string]*Node{
+		"a": NewNode(), "b": NewNode(), "c": NewNode(), "d": NewNode(),
+	}
+
+	report = drain.CheckConsistency()
+	require.Empty(t, report.DanglingClusterIds)
+	require.Equal(t, []int64{2, 3}, report.MissingClusterIds)
+	require.Equal(t, []string{"root/<L=2>"}, report.MaxChildrenViolations)
+	require.False(t, report.IsConsistent())
+}
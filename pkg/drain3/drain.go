@@ -6,8 +6,10 @@ import (
 	"fmt"
 	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/jaeyo/go-drain3/util"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 )
 
@@ -37,9 +39,27 @@ type Drain struct {
 	ExtraDelimiters          []string
 	ParamStr                 string
 	ParametrizeNumericTokens bool
+	RebuildTreeOnLoad        bool
+	KeyValueTokens           bool
+	VariableLength           bool
+	VariableParamStr         string
+	MaxLengthDelta           int64
+	MaxClusterSamples        int
+
+	// SimThStrategy is a runtime setting which is not persisted, SimTh is used when it is nil.
+	// the statistics of a LearningSimThStrategy are persisted and handed over to the strategy by TemplateMiner.LoadState
+	SimThStrategy SimThStrategy `json:"-"`
+	// TokenWeigher is a runtime setting which is not persisted, every token weighs the same when it is nil
+	TokenWeigher TokenWeigher `json:"-"`
 
 	IdToCluster     *lru.Cache[int64, *LogCluster] `json:"-"`
 	ClustersCounter int64
+
+	// clusters whose template had a variable parameter, which alignmentSearch tries for messages of any length.
+	// ids of clusters evicted or without a variable parameter since are dropped by alignmentSearch
+	variableParamClusterIds map[int64]struct{}
+	// statistics of a LearningSimThStrategy loaded from a snapshot, which LoadState hands over to the strategy
+	leafSimStats map[string]*LeafSimStats
 }
 
 type optionFn func(*Drain)
@@ -74,6 +94,53 @@ func WithExtraDelimiter(extraDelimiter []string) optionFn {
 	}
 }
 
+// WithRebuildTreeOnLoad persists only the clusters, the prefix tree is rebuilt from them when the state is loaded.
+// the rebuilt tree routes messages like the original one as long as no node reached MaxChildren,
+// past that the nodes of evicted clusters and the order of insertion are lost and some messages may match other clusters
+func WithRebuildTreeOnLoad() optionFn {
+	return func(drain *Drain) {
+		drain.RebuildTreeOnLoad = true
+	}
+}
+
+// WithKeyValueTokens treats key=value tokens as a constant key and a value which is the only part to be parametrized
+func WithKeyValueTokens() optionFn {
+	return func(drain *Drain) {
+		drain.KeyValueTokens = true
+	}
+}
+
+// WithVariableLength lets templates absorb a variable number of tokens in a variable parameter (<*...>).
+// messages the prefix tree finds no match for are aligned against templates differing by at most maxLengthDelta tokens.
+// it cannot be combined with WithKeyValueTokens or WithTokenWeigher
+func WithVariableLength(maxLengthDelta int64) optionFn {
+	return func(drain *Drain) {
+		drain.VariableLength = true
+		drain.MaxLengthDelta = maxLengthDelta
+	}
+}
+
+// WithMaxClusterSamples keeps up to maxSamples distinct recent messages per cluster, which SplitCluster needs
+func WithMaxClusterSamples(maxSamples int) optionFn {
+	return func(drain *Drain) {
+		drain.MaxClusterSamples = maxSamples
+	}
+}
+
+// WithSimThStrategy replaces the global SimTh by a threshold decided per message and prefix tree leaf
+func WithSimThStrategy(strategy SimThStrategy) optionFn {
+	return func(drain *Drain) {
+		drain.SimThStrategy = strategy
+	}
+}
+
+// WithTokenWeigher weighs the tokens compared when looking for the cluster of a message
+func WithTokenWeigher(weigher TokenWeigher) optionFn {
+	return func(drain *Drain) {
+		drain.TokenWeigher = weigher
+	}
+}
+
 func NewDrain(options ...optionFn) (*Drain, error) {
 	drain := &Drain{
 		LogClusterDepth:          4,
@@ -84,6 +151,7 @@ func NewDrain(options ...optionFn) (*Drain, error) {
 		ExtraDelimiters:          []string{},
 		ParamStr:                 "<*>",
 		ParametrizeNumericTokens: true,
+		VariableParamStr:         defaultVariableParamStr,
 
 		ClustersCounter: 0,
 	}
@@ -94,9 +162,13 @@ func NewDrain(options ...optionFn) (*Drain, error) {
 
 	if drain.LogClusterDepth < 3 {
 		return nil, errors.New("depth argument must be at least 3")
+	} else if drain.VariableLength && (drain.KeyValueTokens || drain.TokenWeigher != nil) {
+		// alignTokens compares raw tokens with the same weight
+		return nil, errors.New("variable length templates support neither key=value tokens nor token weighers")
 	}
 
 	drain.MaxNodeDepth = drain.LogClusterDepth - 2 // max depth of a prefix tree node, starting from zero
+	drain.setTokenWeigherParams()
 
 	l, err := lru.New[int64, *LogCluster](drain.MaxClusters)
 	if err != nil {
@@ -108,11 +180,26 @@ func NewDrain(options ...optionFn) (*Drain, error) {
 }
 
 func (d *Drain) AddLogMessage(content string) (*LogCluster, ClusterUpdateType, error) {
+	cluster, updateType, _, err := d.addLogMessage("", content)
+	return cluster, updateType, err
+}
+
+// addLogMessage adds a message like AddLogMessage and also returns its tokens.
+// the message is only matched against the clusters of the same partition, see LogCluster.Partition
+func (d *Drain) addLogMessage(partition, content string) (*LogCluster, ClusterUpdateType, []string, error) {
 	contentTokens := d.getContentAsTokens(content)
+	if d.TokenWeigher != nil {
+		d.TokenWeigher.Observe(contentTokens)
+	}
 
-	matchCluster, err := d.treeSearch(d.RootNode, contentTokens, d.SimTh, false)
+	matchCluster, simTh, err := d.leafMatch(partition, contentTokens)
 	if err != nil {
-		return nil, ClusterUpdateTypeNone, fmt.Errorf("failed to tree search: %w", err)
+		return nil, ClusterUpdateTypeNone, nil, fmt.Errorf("failed to tree search: %w", err)
+	}
+
+	var matchAlignment *alignment
+	if matchCluster == nil && d.VariableLength {
+		matchCluster, matchAlignment = d.alignmentSearch(partition, contentTokens, simTh, false)
 	}
 
 	updateType := ClusterUpdateTypeNone
@@ -122,14 +209,35 @@ func (d *Drain) AddLogMessage(content string) (*LogCluster, ClusterUpdateType, e
 		d.ClustersCounter++
 		clusterId := d.ClustersCounter
 		matchCluster = NewLogCluster(clusterId, contentTokens)
+		matchCluster.Partition = partition
 		d.IdToCluster.Add(clusterId, matchCluster)
 		d.addSeqToPrefixTree(d.RootNode, matchCluster)
+		d.trackVariableParam(matchCluster)
 		updateType = ClusterUpdateTypeCreated
+	} else if matchAlignment != nil {
+		// add the new log message to a cluster of another token count, which moves in the prefix tree if its template changes
+		newTemplateTokens := d.createAlignedTemplate(matchCluster.LogTemplateTokens, contentTokens, matchAlignment)
+		if util.IsSliceEqual(newTemplateTokens, matchCluster.LogTemplateTokens) {
+			updateType = ClusterUpdateTypeNone
+		} else {
+			previousLength := len(matchCluster.LogTemplateTokens)
+			d.removeFromPrefixTree(matchCluster)
+			matchCluster.LogTemplateTokens = newTemplateTokens
+			d.retainParamSlots(matchCluster, previousLength)
+			d.addSeqToPrefixTree(d.RootNode, matchCluster)
+			d.trackVariableParam(matchCluster)
+			updateType = ClusterUpdateTypeTemplateChanged
+		}
+
+		matchCluster.Size++
+
+		// touch cluster to update its state in the cache
+		d.IdToCluster.Get(matchCluster.ClusterId)
 	} else {
 		// add the new log message to the existing cluster
 		newTemplateTokens, err := d.createTemplate(contentTokens, matchCluster.LogTemplateTokens)
 		if err != nil {
-			return nil, ClusterUpdateTypeNone, fmt.Errorf("failed to create template: %w", err)
+			return nil, ClusterUpdateTypeNone, nil, fmt.Errorf("failed to create template: %w", err)
 		}
 
 		if util.IsSliceEqual(newTemplateTokens, matchCluster.LogTemplateTokens) {
@@ -145,7 +253,10 @@ func (d *Drain) AddLogMessage(content string) (*LogCluster, ClusterUpdateType, e
 		d.IdToCluster.Get(matchCluster.ClusterId)
 	}
 
-	return matchCluster, updateType, nil
+	matchCluster.addSample(content, d.MaxClusterSamples)
+	matchCluster.LastAccessTime = time.Now().UTC()
+
+	return matchCluster, updateType, contentTokens, nil
 }
 
 func (d *Drain) getContentAsTokens(content string) []string {
@@ -156,23 +267,32 @@ func (d *Drain) getContentAsTokens(content string) []string {
 	return strings.Split(content, " ")
 }
 
-func (d *Drain) treeSearch(rootNode *Node, tokens []string, simTh float64, includeParams bool) (*LogCluster, error) {
-	// at first level, children are grouped by token (word) count
+func (d *Drain) treeSearch(rootNode *Node, partition string, tokens []string, simTh float64, includeParams bool) (*LogCluster, error) {
+	return d.leafSearch(d.searchLeaf(rootNode, partition, tokens), tokens, simTh, includeParams)
+}
+
+// searchLeaf returns the prefix tree leaf the tokens of a partition are routed to, nil if there is none
+func (d *Drain) searchLeaf(rootNode *Node, partition string, tokens []string) *Node {
+	leaf, _ := d.searchLeafPath(rootNode, partition, tokens, false)
+	return leaf
+}
+
+// searchLeafPath is searchLeaf also returning the keys of the nodes leading to the leaf when withPath is set.
+// unlike the leaf node, the path stays the same when the tree is rebuilt or loaded
+func (d *Drain) searchLeafPath(rootNode *Node, partition string, tokens []string, withPath bool) (*Node, string) {
+	// at first level, children are grouped by partition and token (word) count
 	tokenCount := len(tokens)
-	currentNode, exist := rootNode.KeyToChildNode[fmt.Sprint(tokenCount)]
+	key := firstLayerKey(partition, tokenCount)
+	currentNode, exist := rootNode.KeyToChildNode[key]
 
 	// no template with same token count yet
 	if !exist {
-		return nil, nil
+		return nil, ""
 	}
 
-	// handle case of empty log string - return the single cluster in that group
-	if tokenCount == 0 {
-		logCluster, exist := d.IdToCluster.Get(currentNode.ClusterIds[0])
-		if !exist {
-			return nil, nil
-		}
-		return logCluster, nil
+	path := strings.Builder{}
+	if withPath {
+		path.WriteString(strconv.Quote(key))
 	}
 
 	// find the leaf node for this log - a path of nodes matching the first N tokens (N=tree depth)
@@ -189,18 +309,41 @@ func (d *Drain) treeSearch(rootNode *Node, tokens []string, simTh float64, inclu
 		}
 
 		keyToChildNode := currentNode.KeyToChildNode
-		currentNode, exist = keyToChildNode[token]
+		key = d.treeKey(token)
+		currentNode, exist = keyToChildNode[key]
 		if !exist { // no exact next token exist, try wildcard node
-			currentNode, exist = keyToChildNode[d.ParamStr]
+			key = d.ParamStr
+			currentNode, exist = keyToChildNode[key]
 		}
 		if !exist { // no wildcard node exist
-			return nil, nil
+			return nil, ""
+		}
+		if withPath {
+			path.WriteString("/" + strconv.Quote(key))
 		}
 
 		currentNodeDepth += 1
 	}
 
-	return d.fastMatch(currentNode.ClusterIds, tokens, simTh, includeParams)
+	return currentNode, path.String()
+}
+
+func (d *Drain) leafSearch(leaf *Node, tokens []string, simTh float64, includeParams bool) (*LogCluster, error) {
+	if leaf == nil {
+		return nil, nil
+	}
+
+	// handle case of empty log string - return the single cluster in that group.
+	// Peek leaves the recency to the caller, which touches the cluster only when the message is added to it
+	if len(tokens) == 0 {
+		logCluster, exist := d.IdToCluster.Peek(leaf.ClusterIds[0])
+		if !exist {
+			return nil, nil
+		}
+		return logCluster, nil
+	}
+
+	return d.fastMatch(leaf.ClusterIds, tokens, simTh, includeParams)
 }
 
 func (d *Drain) fastMatch(clusterIds []int64, tokens []string, simTh float64, includeParams bool) (*LogCluster, error) {
@@ -213,20 +356,34 @@ func (d *Drain) fastMatch(clusterIds []int64, tokens []string, simTh float64, in
 
 	var matchCluster *LogCluster
 
+	maxCluster, maxSim, err := d.bestMatch(clusterIds, tokens, includeParams)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxSim >= simTh {
+		matchCluster = maxCluster
+	}
+
+	return matchCluster, nil
+}
+
+// bestMatch returns the most similar cluster and its similarity, -1 when none of the clusters exists anymore
+func (d *Drain) bestMatch(clusterIds []int64, tokens []string, includeParams bool) (*LogCluster, float64, error) {
 	maxSim := float64(-1)
 	maxParamCount := int64(-1)
 	var maxCluster *LogCluster
 
 	for _, clusterId := range clusterIds {
 		// try to retrieve cluster from cache with bypassing eviction algorithm as we are only testing candidates for a match
-		cluster, exist := d.IdToCluster.Get(clusterId)
+		cluster, exist := d.IdToCluster.Peek(clusterId)
 		if !exist {
 			continue
 		}
 
 		currentSim, paramCount, err := d.getSeqDistance(cluster.LogTemplateTokens, tokens, includeParams)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get sequence distance: %w", err)
+			return nil, 0, fmt.Errorf("failed to get sequence distance: %w", err)
 		}
 
 		if currentSim > maxSim || (currentSim == maxSim && paramCount > maxParamCount) {
@@ -236,11 +393,7 @@ func (d *Drain) fastMatch(clusterIds []int64, tokens []string, simTh float64, in
 		}
 	}
 
-	if maxSim >= simTh {
-		matchCluster = maxCluster
-	}
-
-	return matchCluster, nil
+	return maxCluster, maxSim, nil
 }
 
 func (d *Drain) getSeqDistance(seq1 []string, seq2 []string, includeParams bool) (float64, int64, error) {
@@ -254,38 +407,73 @@ func (d *Drain) getSeqDistance(seq1 []string, seq2 []string, includeParams bool)
 		return 1, 0, nil
 	}
 
-	simTokens := int64(0)
+	simTokens := float64(0)
 	paramCount := int64(0)
+	paramTokens := float64(0)
+	tokenCount := float64(0)
 
 	for i := 0; i < len(seq1); i++ {
 		token1 := seq1[i]
 		token2 := seq2[i]
 
-		if token1 == d.ParamStr {
+		// every token counts once unless a token weigher tells otherwise
+		weight := float64(1)
+		if d.TokenWeigher != nil {
+			weight = d.TokenWeigher.Weight(i, len(seq1), token1)
+		}
+		tokenCount += weight
+
+		// key=value tokens sharing their key count as a matching key token followed by their value tokens
+		if d.KeyValueTokens {
+			key1, value1, isKeyValue1 := splitKeyValue(token1)
+			key2, value2, isKeyValue2 := splitKeyValue(token2)
+			if isKeyValue1 && isKeyValue2 && key1 == key2 {
+				simTokens += weight
+				tokenCount += weight
+				token1, token2 = value1, value2
+			}
+		}
+
+		if d.isParam(token1) {
 			paramCount++
+			paramTokens += weight
 			continue
 		}
 
 		if token1 == token2 {
-			simTokens++
+			simTokens += weight
 		}
 	}
 
 	if includeParams {
-		simTokens += paramCount
+		simTokens += paramTokens
+	}
+
+	// all tokens may weigh nothing, which is as uninformative as an empty sequence
+	if tokenCount == 0 {
+		return 1, paramCount, nil
 	}
 
-	retVal := float64(simTokens) / float64(len(seq1))
+	retVal := simTokens / tokenCount
 	return retVal, paramCount, nil
 }
 
+// firstLayerKey returns the key of the child of the root holding the templates of a partition having the token count.
+// keys of the default partition are the token count alone, as in the reference implementation
+func firstLayerKey(partition string, tokenCount int) string {
+	if partition == "" {
+		return strconv.Itoa(tokenCount)
+	}
+	return partition + "/" + strconv.Itoa(tokenCount)
+}
+
 func (d *Drain) addSeqToPrefixTree(rootNode *Node, cluster *LogCluster) {
 	tokenCount := len(cluster.LogTemplateTokens)
-	tokenCountStr := fmt.Sprint(tokenCount)
-	firstLayerNode, exist := rootNode.KeyToChildNode[tokenCountStr]
+	firstLayerKey := firstLayerKey(cluster.Partition, tokenCount)
+	firstLayerNode, exist := rootNode.KeyToChildNode[firstLayerKey]
 	if !exist {
 		firstLayerNode = NewNode()
-		rootNode.KeyToChildNode[tokenCountStr] = firstLayerNode
+		rootNode.KeyToChildNode[firstLayerKey] = firstLayerNode
 	}
 
 	currentNode := firstLayerNode
@@ -298,12 +486,15 @@ func (d *Drain) addSeqToPrefixTree(rootNode *Node, cluster *LogCluster) {
 
 	currentDepth := int64(1)
 	for _, token := range cluster.LogTemplateTokens {
+		token = d.treeKey(token)
+
 		// if at max depth or this is last token in template - add current log cluster to the leaf node
 		if currentDepth >= d.MaxNodeDepth || currentDepth >= int64(tokenCount) {
 			// clean up stale clusters before adding a new one.
+			// Contains does not refresh the recency of the other clusters of the leaf, unlike Get
 			newClusterIds := []int64{}
 			for _, clusterId := range currentNode.ClusterIds {
-				if _, exist := d.IdToCluster.Get(clusterId); exist {
+				if d.IdToCluster.Contains(clusterId) {
 					newClusterIds = append(newClusterIds, clusterId)
 				}
 			}
@@ -362,8 +553,8 @@ func (d *Drain) createTemplate(seq1, seq2 []string) ([]string, error) {
 	copy(retVal, seq2)
 
 	for i := 0; i < len(seq1); i++ {
-		if seq1[i] != seq2[i] {
-			retVal[i] = d.ParamStr
+		if seq1[i] != seq2[i] && !d.isVariableParam(seq2[i]) {
+			retVal[i] = d.paramTokenFor(seq1[i], seq2[i])
 		}
 	}
 
@@ -383,6 +574,11 @@ func (d *Drain) Match(content string, strategy SearchStrategy) (*LogCluster, err
 	// (3) "always" is the slowest. it will select the best among all known clusters, be always evaluating all clusters with the same token count, and selecting the cluster with perfect all token match and least count of wildcard matches.
 	// return: matched cluster of nil if no match found
 
+	return d.match("", content, strategy)
+}
+
+// match is Match among the clusters of a partition
+func (d *Drain) match(partition, content string, strategy SearchStrategy) (*LogCluster, error) {
 	requiredSimTh := 1.0
 	contentTokens := d.getContentAsTokens(content)
 
@@ -392,12 +588,17 @@ func (d *Drain) Match(content string, strategy SearchStrategy) (*LogCluster, err
 	// also fast match can be optimized when exact match is required by early quitting on less than exact cluster matches.
 
 	fullSearch := func() (*LogCluster, error) {
-		allIds := d.getClustersIdsForSeqLen(len(contentTokens))
+		allIds := d.getClustersIdsForSeqLen(partition, len(contentTokens))
 		cluster, err := d.fastMatch(allIds, contentTokens, requiredSimTh, true)
 		if err != nil {
 			return nil, fmt.Errorf("failed to fast match: %w", err)
 		}
 
+		// templates of other token counts are only reachable by aligning against them
+		if cluster == nil && d.VariableLength {
+			cluster, _ = d.alignmentSearch(partition, contentTokens, requiredSimTh, true)
+		}
+
 		return cluster, nil
 	}
 
@@ -405,7 +606,7 @@ func (d *Drain) Match(content string, strategy SearchStrategy) (*LogCluster, err
 		return fullSearch()
 	}
 
-	matchCluster, err := d.treeSearch(d.RootNode, contentTokens, requiredSimTh, true)
+	matchCluster, err := d.treeSearch(d.RootNode, partition, contentTokens, requiredSimTh, true)
 	if err != nil {
 		return nil, fmt.Errorf("failed to tree search: %w", err)
 	} else if matchCluster != nil {
@@ -419,8 +620,8 @@ func (d *Drain) Match(content string, strategy SearchStrategy) (*LogCluster, err
 	return fullSearch()
 }
 
-func (d *Drain) getClustersIdsForSeqLen(seqLen int) []int64 {
-	// return all clusters with the specified count of tokens
+func (d *Drain) getClustersIdsForSeqLen(partition string, seqLen int) []int64 {
+	// return all clusters of the partition with the specified count of tokens
 
 	var appendClusterRecursive func(node *Node, idListToFil *[]int64)
 	appendClusterRecursive = func(node *Node, idListToFill *[]int64) {
@@ -430,7 +631,7 @@ func (d *Drain) getClustersIdsForSeqLen(seqLen int) []int64 {
 		}
 	}
 
-	currentNode, exist := d.RootNode.KeyToChildNode[strconv.Itoa(seqLen)]
+	currentNode, exist := d.RootNode.KeyToChildNode[firstLayerKey(partition, seqLen)]
 
 	// no template with same token count
 	if !exist {
@@ -446,6 +647,20 @@ func (d *Drain) GetClusters() []*LogCluster {
 	return d.IdToCluster.Values()
 }
 
+// forgetEvictedClusters deletes the state kept per cluster id for the clusters evicted from the drain,
+// once there are twice as many ids as the drain can hold clusters
+func forgetEvictedClusters[V any](drain *Drain, clusterStates map[int64]V) {
+	if len(clusterStates) < 2*drain.MaxClusters {
+		return
+	}
+
+	for id := range clusterStates {
+		if !drain.IdToCluster.Contains(id) {
+			delete(clusterStates, id)
+		}
+	}
+}
+
 func (d *Drain) PrintTree(maxClusters int) {
 	d.printNode("root", d.RootNode, 0, maxClusters)
 }
@@ -471,7 +686,8 @@ func (d *Drain) printNode(token string, node *Node, depth int, maxClusters int)
 	}
 
 	for _, clusterId := range node.ClusterIds[:min(len(node.ClusterIds), maxClusters)] {
-		cluster, exist := d.IdToCluster.Get(clusterId)
+		// printing must not change which clusters are evicted next
+		cluster, exist := d.IdToCluster.Peek(clusterId)
 		if !exist {
 			continue
 		}
@@ -482,53 +698,117 @@ func (d *Drain) printNode(token string, node *Node, depth int, maxClusters int)
 }
 
 func (d *Drain) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.toSerializable())
+}
+
+func (d *Drain) UnmarshalJSON(data []byte) error {
+	data, err := migrateSnapshot(data)
+	if err != nil {
+		return fmt.Errorf("failed to migrate snapshot: %w", err)
+	}
+
+	var forJson SerializableDrain
+	if err := json.Unmarshal(data, &forJson); err != nil {
+		return err
+	}
+
+	return d.fromSerializable(&forJson)
+}
+
+func (d *Drain) toSerializable() *SerializableDrain {
+	// clusters are listed from least to most recently used, so that loading them in order restores the recency
 	clusters := []*LogCluster{}
 	clusters = append(clusters, d.IdToCluster.Values()...)
 
-	return json.Marshal(&SerializableDrain{
+	rootNode := d.RootNode
+	if d.RebuildTreeOnLoad {
+		rootNode = nil
+	}
+
+	return &SerializableDrain{
+		Version:                  SnapshotVersion,
 		LogClusterDepth:          d.LogClusterDepth,
 		MaxNodeDepth:             d.MaxNodeDepth,
 		SimTh:                    d.SimTh,
 		MaxChildren:              d.MaxChildren,
-		RootNode:                 d.RootNode,
+		RootNode:                 rootNode,
 		MaxClusters:              d.MaxClusters,
 		ExtraDelimiters:          d.ExtraDelimiters,
 		ParamStr:                 d.ParamStr,
 		ParametrizeNumericTokens: d.ParametrizeNumericTokens,
+		RebuildTreeOnLoad:        d.RebuildTreeOnLoad,
+		KeyValueTokens:           d.KeyValueTokens,
+		VariableLength:           d.VariableLength,
+		VariableParamStr:         d.VariableParamStr,
+		MaxLengthDelta:           d.MaxLengthDelta,
+		MaxClusterSamples:        d.MaxClusterSamples,
 
 		Clusters:        clusters,
 		ClustersCounter: d.ClustersCounter,
-	})
+		LeafSimStats:    d.learnedLeafStats(),
+	}
 }
 
-func (d *Drain) UnmarshalJSON(data []byte) error {
-	var forJson SerializableDrain
-	err := json.Unmarshal(data, &forJson)
+func (d *Drain) fromSerializable(serializable *SerializableDrain) error {
+	l, err := lru.New[int64, *LogCluster](serializable.MaxClusters)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to create lru-cache: %w", err)
 	}
 
-	l, _ := lru.New[int64, *LogCluster](forJson.MaxClusters)
-	for _, cluster := range forJson.Clusters {
+	// add clusters from least to most recently used so that the cache evicts the same clusters the original would have,
+	// including when there are more clusters than MaxClusters
+	for _, cluster := range serializable.Clusters {
 		l.Add(cluster.ClusterId, cluster)
 	}
 
-	d.LogClusterDepth = forJson.LogClusterDepth
-	d.MaxNodeDepth = forJson.MaxNodeDepth
-	d.SimTh = forJson.SimTh
-	d.MaxChildren = forJson.MaxChildren
-	d.RootNode = forJson.RootNode
-	d.MaxClusters = forJson.MaxClusters
-	d.ExtraDelimiters = forJson.ExtraDelimiters
-	d.ParamStr = forJson.ParamStr
-	d.ParametrizeNumericTokens = forJson.ParametrizeNumericTokens
+	d.LogClusterDepth = serializable.LogClusterDepth
+	d.MaxNodeDepth = serializable.MaxNodeDepth
+	d.SimTh = serializable.SimTh
+	d.MaxChildren = serializable.MaxChildren
+	d.RootNode = serializable.RootNode
+	d.MaxClusters = serializable.MaxClusters
+	d.ExtraDelimiters = serializable.ExtraDelimiters
+	d.ParamStr = serializable.ParamStr
+	d.ParametrizeNumericTokens = serializable.ParametrizeNumericTokens
+	d.RebuildTreeOnLoad = serializable.RebuildTreeOnLoad
+	d.KeyValueTokens = serializable.KeyValueTokens
+	d.VariableLength = serializable.VariableLength
+	d.VariableParamStr = serializable.VariableParamStr
+	d.MaxLengthDelta = serializable.MaxLengthDelta
+	d.MaxClusterSamples = serializable.MaxClusterSamples
 	d.IdToCluster = l
-	d.ClustersCounter = forJson.ClustersCounter
+	d.ClustersCounter = serializable.ClustersCounter
+	d.leafSimStats = serializable.LeafSimStats
+
+	d.variableParamClusterIds = nil
+	for _, cluster := range l.Values() {
+		d.trackVariableParam(cluster)
+	}
+
+	if d.RootNode == nil {
+		d.rebuildPrefixTree()
+	}
 
 	return nil
 }
 
+func (d *Drain) rebuildPrefixTree() {
+	d.RootNode = NewNode()
+
+	// add clusters in creation order, which is the order the original tree received them.
+	// the clusters are added with their final templates, which lead to the same leaves unless a node reached MaxChildren
+	clusters := d.IdToCluster.Values()
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].ClusterId < clusters[j].ClusterId
+	})
+
+	for _, cluster := range clusters {
+		d.addSeqToPrefixTree(d.RootNode, cluster)
+	}
+}
+
 type SerializableDrain struct {
+	Version                  int
 	LogClusterDepth          int64
 	MaxNodeDepth             int64
 	SimTh                    float64
@@ -538,9 +818,17 @@ type SerializableDrain struct {
 	ExtraDelimiters          []string
 	ParamStr                 string
 	ParametrizeNumericTokens bool
-
-	Clusters        []*LogCluster
+	RebuildTreeOnLoad        bool
+	KeyValueTokens           bool
+	VariableLength           bool
+	VariableParamStr         string
+	MaxLengthDelta           int64
+	MaxClusterSamples        int
+
+	Clusters        []*LogCluster // from least to most recently used
 	ClustersCounter int64
+	// statistics learned by a LearningSimThStrategy by leaf path
+	LeafSimStats map[string]*LeafSimStats
 }
 
 type Node struct {
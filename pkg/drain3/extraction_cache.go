@@ -0,0 +1,152 @@
+package drain3
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// the drain catch-all mask, the only mask the extraction regex captures besides variable parameters
+const catchAllMask = "<*>"
+
+// templateMatcher holds what ExtractParameters derives from a template, so that it is computed once per template
+type templateMatcher struct {
+	tokens []string
+	// whether the template can be matched token by token: it has no variable parameter and at most one mask per token
+	tokenAligned bool
+	regex        *regexp.Regexp
+	// mask name of every capture group of the regex, empty for groups which are not parameters
+	groupMaskNames []string
+	parameters     []*templateParameter
+}
+
+func (m *TemplateMiner) newTemplateMatcher(logTemplate string) *templateMatcher {
+	templateRegex, paramGroupNameToMaskName := m.getTemplateParameterExtractionRegex(logTemplate)
+	regex := regexp.MustCompile(templateRegex)
+
+	groupMaskNames := make([]string, len(regex.SubexpNames()))
+	for i, groupName := range regex.SubexpNames() {
+		groupMaskNames[i] = paramGroupNameToMaskName[groupName]
+	}
+
+	tokens := strings.Split(logTemplate, " ")
+	tokenAligned := true
+	for _, token := range tokens {
+		isVariable := m.drain.VariableLength && strings.Contains(token, m.drain.VariableParamStr)
+		tokenAligned = tokenAligned && !isVariable && strings.Count(token, catchAllMask) <= 1
+	}
+
+	return &templateMatcher{
+		tokens:         tokens,
+		tokenAligned:   tokenAligned,
+		regex:          regex,
+		groupMaskNames: groupMaskNames,
+		parameters:     m.drain.getTemplateParameters(logTemplate),
+	}
+}
+
+// getTemplateMatcher returns the matcher of a template from the cache.
+// matchers are keyed by template, so a cluster whose template tokens change gets a new matcher and the old one ages out
+func (m *TemplateMiner) getTemplateMatcher(logTemplate string) *templateMatcher {
+	if m.matchers == nil {
+		return m.newTemplateMatcher(logTemplate)
+	}
+
+	if matcher, exist := m.matchers.Get(logTemplate); exist {
+		return matcher
+	}
+
+	matcher := m.newTemplateMatcher(logTemplate)
+	m.matchers.Add(logTemplate, matcher)
+	return matcher
+}
+
+// templateParameters returns the parameters of a template, from the cached matcher unless the cache is disabled
+func (m *TemplateMiner) templateParameters(logTemplate string) []*templateParameter {
+	if m.matchers == nil {
+		return m.drain.getTemplateParameters(logTemplate)
+	}
+	return m.getTemplateMatcher(logTemplate).parameters
+}
+
+// compileExtraDelimiters compiles the extra delimiters of the drain once, when the miner is created or loads a state,
+// so that concurrent extractions only read them. delimiters changed on the drain afterwards are not taken into account
+func (m *TemplateMiner) compileExtraDelimiters() {
+	m.delimiterRegexes = []*regexp.Regexp{}
+	if m.drain == nil {
+		return
+	}
+
+	for _, delimiter := range m.drain.ExtraDelimiters {
+		m.delimiterRegexes = append(m.delimiterRegexes, regexp.MustCompile(delimiter))
+	}
+}
+
+// replaceExtraDelimiters replaces the extra delimiters of the drain by spaces
+func (m *TemplateMiner) replaceExtraDelimiters(logMessage string) string {
+	for _, delimiterRegex := range m.delimiterRegexes {
+		logMessage = delimiterRegex.ReplaceAllString(logMessage, " ")
+	}
+	return logMessage
+}
+
+// match returns the value and the mask name of every parameter of the message in order, nil when it does not match
+func (t *templateMatcher) match(logMessage string) ([]string, []string) {
+	if t.tokenAligned {
+		if values, ok := t.matchTokens(logMessage); ok {
+			maskNames := make([]string, len(values))
+			for i := range maskNames {
+				maskNames[i] = "*"
+			}
+			return values, maskNames
+		}
+	}
+
+	parameterMatch := t.regex.FindStringSubmatch(logMessage)
+	if parameterMatch == nil {
+		return nil, nil
+	}
+
+	values, maskNames := []string{}, []string{}
+	for i, maskName := range t.groupMaskNames {
+		if maskName != "" {
+			values = append(values, parameterMatch[i])
+			maskNames = append(maskNames, maskName)
+		}
+	}
+	return values, maskNames
+}
+
+// matchTokens extracts the parameters of a message with as many tokens as the template without a regex.
+// tokens must be separated by single spaces, so that the regex could not align them differently.
+// false is returned when the regex has to decide
+func (t *templateMatcher) matchTokens(logMessage string) ([]string, bool) {
+	tokens := strings.Split(logMessage, " ")
+	if len(tokens) != len(t.tokens) {
+		return nil, false
+	}
+
+	values := []string{}
+	for i, token := range tokens {
+		if token == "" || strings.IndexFunc(token, unicode.IsSpace) >= 0 {
+			return nil, false
+		}
+
+		templateToken := t.tokens[i]
+		index := strings.Index(templateToken, catchAllMask)
+		if index < 0 {
+			if token != templateToken {
+				return nil, false
+			}
+			continue
+		}
+
+		prefix, suffix := templateToken[:index], templateToken[index+len(catchAllMask):]
+		if len(token) <= len(prefix)+len(suffix) || !strings.HasPrefix(token, prefix) || !strings.HasSuffix(token, suffix) {
+			return nil, false
+		}
+		values = append(values, token[len(prefix):len(token)-len(suffix)])
+	}
+
+	return values, true
+}
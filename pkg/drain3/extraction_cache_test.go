@@ -0,0 +1,160 @@
+package drain3
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// regexParameters extracts the parameters of a message with the template regex only
+func regexParameters(miner *TemplateMiner, logTemplate, logMessage string) []string {
+	matcher := miner.newTemplateMatcher(logTemplate)
+	matcher.tokenAligned = false
+	values, _ := matcher.match(miner.replaceExtraDelimiters(logMessage))
+	return values
+}
+
+func TestExtractParametersFastPath(t *testing.T) {
+	drain, err := NewDrain(WithExtraDelimiter([]string{"_"}), WithKeyValueTokens())
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence())
+
+	messages := []string{
+		"took 3 ms",
+		"took 3  ms",
+		"took\t3 ms",
+		"took 3",
+		"id=42 took 3ms",
+		"retry_3 of 5",
+		"took  ms",
+	}
+	templates := []string{"took <*> ms", "id=<*> took <*>ms", "retry <*> of <*>", "<*>x<*> <*>"}
+
+	for _, message := range messages {
+		for _, template := range templates {
+			expected := regexParameters(miner, template, message)
+			values := []string(nil)
+			for _, parameter := range miner.ExtractParameters(template, message) {
+				values = append(values, parameter.Value)
+			}
+			require.Equal(t, expected, values, "%s / %s", template, message)
+		}
+	}
+
+	matcher := miner.getTemplateMatcher("id=<*> took <*>ms")
+	require.True(t, matcher.tokenAligned)
+	values, ok := matcher.matchTokens("id=42 took 3ms")
+	require.True(t, ok)
+	require.Equal(t, []string{"42", "3"}, values)
+
+	// several masks in a token are left to the regex
+	require.False(t, miner.getTemplateMatcher("<*>x<*> <*>").tokenAligned)
+}
+
+func TestExtractParametersFastPathMatchesRegex(t *testing.T) {
+	for _, options := range [][]optionFn{{}, {WithExtraDelimiter([]string{","})}, {WithVariableLength(3)}} {
+		drain, err := NewDrain(options...)
+		require.NoError(t, err)
+		miner := NewTemplateMiner(drain, NewMemoryPersistence())
+
+		lines := append(generateLines(rand.New(rand.NewSource(2)), 300, tokenSeparators(drain.ExtraDelimiters)), kafkaLogs...)
+		for _, line := range lines {
+			_, err := miner.AddLogLine(context.Background(), line)
+			require.NoError(t, err)
+		}
+
+		for _, line := range lines {
+			cluster, err := miner.Match(line, SearchStrategyAlways)
+			require.NoError(t, err)
+			template := cluster.GetTemplate()
+
+			values := []string(nil)
+			for _, parameter := range miner.ExtractParameters(template, line) {
+				values = append(values, parameter.Value)
+			}
+			require.Equal(t, regexParameters(miner, template, line), values, line)
+		}
+	}
+}
+
+func TestExtractionCache(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithExtractionCacheSize(2))
+
+	for _, template := range []string{"a <*>", "b <*>", "c <*>", "a <*>"} {
+		require.Len(t, miner.ExtractParameters(template, template[:1]+" x"), 1)
+	}
+	require.Equal(t, 2, miner.matchers.Len())
+	require.ElementsMatch(t, []string{"c <*>", "a <*>"}, miner.matchers.Keys())
+
+	// a cluster whose template changes is matched against its new template
+	cluster, _, err := drain.AddLogMessage("user 1 logged in")
+	require.NoError(t, err)
+	require.Empty(t, miner.ExtractParameters(cluster.GetTemplate(), "user 1 logged in"))
+	_, _, err = drain.AddLogMessage("user 2 logged in")
+	require.NoError(t, err)
+	require.Len(t, miner.ExtractParameters(cluster.GetTemplate(), "user 1 logged in"), 1)
+
+	// extra delimiters are compiled when the miner is created
+	drain.ExtraDelimiters = []string{"_"}
+	require.Empty(t, miner.ExtractParameters("user <*> logged in", "user_1 logged in"))
+
+	uncached := NewTemplateMiner(drain, NewMemoryPersistence(), WithExtractionCacheSize(0))
+	require.Nil(t, uncached.matchers)
+	require.Len(t, uncached.ExtractParameters("user <*> logged in", "user_1 logged in"), 1)
+}
+
+func BenchmarkExtractParameters(b *testing.B) {
+	drain, err := NewDrain()
+	require.NoError(b, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence())
+	for _, log := range kafkaLogs {
+		_, _, err := drain.AddLogMessage(log)
+		require.NoError(b, err)
+	}
+
+	templates := []string{}
+	for _, log := range kafkaLogs {
+		cluster, err := drain.Match(log, SearchStrategyAlways)
+		require.NoError(b, err)
+		templates = append(templates, cluster.GetTemplate())
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index := i % len(kafkaLogs)
+		miner.ExtractParameters(templates[index], kafkaLogs[index])
+	}
+}
+
+func TestExtractParametersConcurrently(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	persistence := NewMemoryPersistence()
+	saved := NewTemplateMiner(drain, persistence)
+	drain.ExtraDelimiters = []string{"_"}
+	require.NoError(t, saved.SaveState(context.Background()))
+
+	// the delimiters of the loaded drain are compiled by LoadState, extractions only read them
+	miner := NewTemplateMiner(nil, persistence)
+	require.NoError(t, miner.LoadState(context.Background()))
+
+	waitGroup := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for j := 0; j < 100; j++ {
+				parameters := miner.ExtractParameters("user <*> logged in", "user_1 logged in")
+				if len(parameters) != 1 || parameters[0].Value != "1" {
+					t.Errorf("unexpected parameters %v", parameters)
+					return
+				}
+			}
+		}()
+	}
+	waitGroup.Wait()
+}
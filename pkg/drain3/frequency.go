@@ -0,0 +1,310 @@
+package drain3
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+type RateEventType int
+
+const (
+	// RateEventTypeBurst is emitted when the count of a bucket is far above the baseline of its cluster
+	RateEventTypeBurst RateEventType = iota
+	// RateEventTypeDrop is emitted when the count of a bucket is far below the baseline of its cluster
+	RateEventTypeDrop
+)
+
+func (t RateEventType) String() string {
+	switch t {
+	case RateEventTypeBurst:
+		return "burst"
+	case RateEventTypeDrop:
+		return "drop"
+	default:
+		return "unknown"
+	}
+}
+
+type RateEvent struct {
+	Type      RateEventType
+	ClusterId int64
+	// template of the cluster, empty when it was evicted
+	Template    string
+	BucketStart time.Time
+	Count       int64
+	// ewma of the counts of the previous buckets
+	Baseline float64
+	ZScore   float64
+}
+
+type BucketCount struct {
+	Start time.Time
+	Count int64
+}
+
+type FrequencyResult struct {
+	*LineResult
+	Events []*RateEvent
+}
+
+// FrequencyTracker counts the lines of every cluster in a ring buffer of fixed width buckets,
+// and compares the count of each completed bucket to an ewma baseline of the cluster to detect bursts and drops
+type FrequencyTracker struct {
+	miner *TemplateMiner
+
+	bucketWidth        time.Duration
+	bucketCount        int
+	alpha              float64
+	threshold          float64
+	baselineBuckets    int64
+	clusterSeries      map[int64]*clusterSeries
+	latestBucket       int64
+	hasObservedBuckets bool
+
+	now func() time.Time
+}
+
+type clusterSeries struct {
+	counts []int64
+	// index of the newest bucket, counted from the unix epoch
+	newest int64
+
+	mean          float64
+	variance      float64
+	closedBuckets int64
+	// type of the deviation of the last completed bucket, nil when it was within the threshold
+	deviation *RateEventType
+}
+
+type frequencyOptionFn func(*FrequencyTracker)
+
+// WithBucketWidth sets the duration counted by each bucket, a minute by default
+func WithBucketWidth(width time.Duration) frequencyOptionFn {
+	return func(tracker *FrequencyTracker) {
+		tracker.bucketWidth = width
+	}
+}
+
+// WithBucketCount sets how many buckets are kept per cluster, 60 by default
+func WithBucketCount(count int) frequencyOptionFn {
+	return func(tracker *FrequencyTracker) {
+		tracker.bucketCount = count
+	}
+}
+
+// WithEWMAAlpha sets the weight of each completed bucket in the baseline, 0.3 by default
+func WithEWMAAlpha(alpha float64) frequencyOptionFn {
+	return func(tracker *FrequencyTracker) {
+		tracker.alpha = alpha
+	}
+}
+
+// WithDeviationThreshold sets how many standard deviations from the baseline a count is a burst or a drop, 3 by default.
+// the standard deviation is at least 1, so that constant series do not turn every change into an event
+func WithDeviationThreshold(threshold float64) frequencyOptionFn {
+	return func(tracker *FrequencyTracker) {
+		tracker.threshold = threshold
+	}
+}
+
+// WithBaselineBuckets sets how many buckets of a cluster complete before its counts are compared to its baseline, 10 by default
+func WithBaselineBuckets(buckets int64) frequencyOptionFn {
+	return func(tracker *FrequencyTracker) {
+		tracker.baselineBuckets = buckets
+	}
+}
+
+// WithFrequencyClock replaces time.Now as the clock AddLogLine counts lines at
+func WithFrequencyClock(now func() time.Time) frequencyOptionFn {
+	return func(tracker *FrequencyTracker) {
+		tracker.now = now
+	}
+}
+
+func NewFrequencyTracker(miner *TemplateMiner, options ...frequencyOptionFn) (*FrequencyTracker, error) {
+	tracker := &FrequencyTracker{
+		miner:           miner,
+		bucketWidth:     time.Minute,
+		bucketCount:     60,
+		alpha:           0.3,
+		threshold:       3,
+		baselineBuckets: 10,
+		clusterSeries:   map[int64]*clusterSeries{},
+		now:             time.Now,
+	}
+
+	for _, option := range options {
+		option(tracker)
+	}
+
+	if tracker.bucketWidth <= 0 || tracker.bucketCount < 1 {
+		return nil, errors.New("bucket width must be positive and bucket count at least 1")
+	} else if tracker.alpha <= 0 || tracker.alpha > 1 {
+		return nil, errors.New("ewma alpha must be in (0, 1]")
+	} else if tracker.threshold <= 0 || tracker.baselineBuckets < 0 {
+		return nil, errors.New("deviation threshold must be positive and baseline buckets not negative")
+	}
+
+	return tracker, nil
+}
+
+// AddLogLine mines a line with AddLogLine and counts it in the current bucket of its cluster.
+// events are returned for the buckets of the cluster the line completed
+func (t *FrequencyTracker) AddLogLine(ctx context.Context, line string) (*FrequencyResult, error) {
+	lineResult, err := t.miner.AddLogLine(ctx, line)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FrequencyResult{
+		LineResult: lineResult,
+		Events:     t.Observe(lineResult.Cluster.ClusterId, t.now()),
+	}, nil
+}
+
+// Observe counts a line of a cluster at the given time, events are returned for the buckets of the cluster it completed.
+// lines older than the buckets kept are ignored
+func (t *FrequencyTracker) Observe(clusterId int64, at time.Time) []*RateEvent {
+	bucket := t.bucketOf(at)
+	t.observeBucket(bucket)
+
+	series, exist := t.clusterSeries[clusterId]
+	if !exist {
+		// Advance may never be called
+		forgetEvictedClusters(t.miner.drain, t.clusterSeries)
+		series = &clusterSeries{counts: make([]int64, t.bucketCount), newest: bucket}
+		t.clusterSeries[clusterId] = series
+	}
+
+	events := t.advance(clusterId, series, bucket)
+	if series.newest-bucket < int64(t.bucketCount) {
+		series.counts[t.ringIndex(bucket)]++
+	}
+
+	return events
+}
+
+// Advance completes the buckets of every cluster up to the given time and returns their events.
+// it should be called periodically, so that clusters which stopped logging are reported as drops
+func (t *FrequencyTracker) Advance(at time.Time) []*RateEvent {
+	bucket := t.bucketOf(at)
+	t.observeBucket(bucket)
+
+	events := []*RateEvent{}
+	for clusterId, series := range t.clusterSeries {
+		if !t.miner.drain.IdToCluster.Contains(clusterId) {
+			delete(t.clusterSeries, clusterId)
+			continue
+		}
+		events = append(events, t.advance(clusterId, series, bucket)...)
+	}
+
+	return events
+}
+
+// Series returns the counts of the buckets kept for a cluster from the oldest, up to the newest bucket seen by the tracker.
+// nil is returned for a cluster without lines
+func (t *FrequencyTracker) Series(clusterId int64) []*BucketCount {
+	series, exist := t.clusterSeries[clusterId]
+	if !exist {
+		return nil
+	}
+
+	counts := make([]*BucketCount, 0, t.bucketCount)
+	for bucket := t.latestBucket - int64(t.bucketCount) + 1; bucket <= t.latestBucket; bucket++ {
+		count := int64(0)
+		if bucket <= series.newest && series.newest-bucket < int64(t.bucketCount) {
+			count = series.counts[t.ringIndex(bucket)]
+		}
+		counts = append(counts, &BucketCount{
+			Start: time.Unix(0, bucket*int64(t.bucketWidth)).UTC(),
+			Count: count,
+		})
+	}
+
+	return counts
+}
+
+func (t *FrequencyTracker) bucketOf(at time.Time) int64 {
+	return at.UnixNano() / int64(t.bucketWidth)
+}
+
+func (t *FrequencyTracker) ringIndex(bucket int64) int {
+	index := int(bucket % int64(t.bucketCount))
+	if index < 0 {
+		index += t.bucketCount
+	}
+	return index
+}
+
+func (t *FrequencyTracker) observeBucket(bucket int64) {
+	if !t.hasObservedBuckets || bucket > t.latestBucket {
+		t.latestBucket = bucket
+		t.hasObservedBuckets = true
+	}
+}
+
+// advance completes the buckets of a cluster before the given one.
+// buckets no longer kept after a long gap are skipped and do not count in the baseline
+func (t *FrequencyTracker) advance(clusterId int64, series *clusterSeries, bucket int64) []*RateEvent {
+	events := []*RateEvent{}
+
+	for series.newest < bucket {
+		if event := t.completeBucket(clusterId, series); event != nil {
+			events = append(events, event)
+		}
+
+		series.newest = max(series.newest+1, bucket-int64(t.bucketCount)+1)
+		series.counts[t.ringIndex(series.newest)] = 0
+	}
+
+	return events
+}
+
+// completeBucket compares the count of the newest bucket of a cluster to its baseline, then adds it to the baseline.
+// an event is returned only when the deviation of the cluster changes, so that a lasting burst is reported once
+func (t *FrequencyTracker) completeBucket(clusterId int64, series *clusterSeries) *RateEvent {
+	count := series.counts[t.ringIndex(series.newest)]
+
+	var event *RateEvent
+	var deviation *RateEventType
+	if series.closedBuckets >= t.baselineBuckets && series.closedBuckets > 0 {
+		zScore := (float64(count) - series.mean) / max(math.Sqrt(series.variance), 1)
+		if math.Abs(zScore) > t.threshold {
+			eventType := RateEventTypeBurst
+			if zScore < 0 {
+				eventType = RateEventTypeDrop
+			}
+			deviation = &eventType
+
+			if series.deviation == nil || *series.deviation != eventType {
+				event = &RateEvent{
+					Type:        eventType,
+					ClusterId:   clusterId,
+					BucketStart: time.Unix(0, series.newest*int64(t.bucketWidth)).UTC(),
+					Count:       count,
+					Baseline:    series.mean,
+					ZScore:      zScore,
+				}
+				if cluster, exist := t.miner.drain.IdToCluster.Peek(clusterId); exist {
+					event.Template = cluster.GetTemplate()
+				}
+			}
+		}
+	}
+	series.deviation = deviation
+
+	if series.closedBuckets == 0 {
+		series.mean = float64(count)
+	} else {
+		diff := float64(count) - series.mean
+		increment := t.alpha * diff
+		series.mean += increment
+		series.variance = (1 - t.alpha) * (series.variance + diff*increment)
+	}
+	series.closedBuckets++
+
+	return event
+}
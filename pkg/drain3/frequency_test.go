@@ -0,0 +1,141 @@
+package drain3
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newTestFrequencyTracker(t *testing.T, options ...frequencyOptionFn) *FrequencyTracker {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	tracker, err := NewFrequencyTracker(NewTemplateMiner(drain, NewMemoryPersistence()), options...)
+	require.NoError(t, err)
+	return tracker
+}
+
+var frequencyStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func seriesCounts(series []*BucketCount) []int64 {
+	counts := []int64{}
+	for _, bucket := range series {
+		counts = append(counts, bucket.Count)
+	}
+	return counts
+}
+
+func TestFrequencyTrackerSeries(t *testing.T) {
+	now := frequencyStart
+	tracker := newTestFrequencyTracker(t, WithBucketCount(4), WithFrequencyClock(func() time.Time {
+		return now
+	}))
+
+	for _, minute := range []int{0, 0, 1, 3, 3, 3} {
+		now = frequencyStart.Add(time.Duration(minute)*time.Minute + time.Second)
+		_, err := tracker.AddLogLine(context.Background(), "disk full")
+		require.NoError(t, err)
+	}
+
+	series := tracker.Series(1)
+	require.Equal(t, []int64{2, 1, 0, 3}, seriesCounts(series))
+	require.Equal(t, frequencyStart, series[0].Start)
+	require.Equal(t, frequencyStart.Add(3*time.Minute), series[3].Start)
+	require.Nil(t, tracker.Series(2))
+
+	// late lines are counted in their bucket while it is kept
+	tracker.Observe(1, frequencyStart.Add(time.Minute))
+	tracker.Observe(1, frequencyStart.Add(-time.Hour))
+	require.Equal(t, []int64{2, 2, 0, 3}, seriesCounts(tracker.Series(1)))
+
+	// buckets of other clusters move the series forward
+	tracker.Observe(2, frequencyStart.Add(5*time.Minute))
+	require.Equal(t, []int64{0, 3, 0, 0}, seriesCounts(tracker.Series(1)))
+	require.Equal(t, []int64{0, 0, 0, 1}, seriesCounts(tracker.Series(2)))
+
+	// a gap longer than the ring clears it
+	tracker.Observe(1, frequencyStart.Add(time.Hour))
+	require.Equal(t, []int64{0, 0, 0, 1}, seriesCounts(tracker.Series(1)))
+}
+
+func TestFrequencyTrackerBurst(t *testing.T) {
+	tracker := newTestFrequencyTracker(t, WithBaselineBuckets(3))
+
+	observeMinute := func(minute, count int) []*RateEvent {
+		events := []*RateEvent{}
+		for i := 0; i < count; i++ {
+			events = append(events, tracker.Observe(1, frequencyStart.Add(time.Duration(minute)*time.Minute))...)
+		}
+		return events
+	}
+
+	for minute := 0; minute < 5; minute++ {
+		require.Empty(t, observeMinute(minute, 2))
+	}
+	require.Empty(t, observeMinute(5, 50))
+
+	// the burst is reported once its first bucket completes, and not again while it lasts
+	events := observeMinute(6, 60)
+	require.Empty(t, observeMinute(7, 2))
+	require.Len(t, events, 1)
+	require.Equal(t, RateEventTypeBurst, events[0].Type)
+	require.Equal(t, frequencyStart.Add(5*time.Minute), events[0].BucketStart)
+	require.Equal(t, int64(50), events[0].Count)
+	require.InDelta(t, 2, events[0].Baseline, 0.001)
+	require.Greater(t, events[0].ZScore, 3.0)
+	require.Equal(t, "burst", events[0].Type.String())
+}
+
+func TestFrequencyTrackerDrop(t *testing.T) {
+	now := frequencyStart
+	tracker := newTestFrequencyTracker(t, WithBaselineBuckets(3), WithBucketWidth(time.Second), WithFrequencyClock(func() time.Time {
+		return now
+	}))
+
+	for second := 0; second < 10; second++ {
+		now = frequencyStart.Add(time.Duration(second) * time.Second)
+		for i := 0; i < 20; i++ {
+			result, err := tracker.AddLogLine(context.Background(), "request done")
+			require.NoError(t, err)
+			require.Empty(t, result.Events)
+		}
+	}
+
+	// the cluster stopped logging, which only shows when the tracker advances
+	events := tracker.Advance(frequencyStart.Add(15 * time.Second))
+	require.Len(t, events, 1)
+	require.Equal(t, RateEventTypeDrop, events[0].Type)
+	require.Equal(t, "request done", events[0].Template)
+	require.Equal(t, frequencyStart.Add(10*time.Second), events[0].BucketStart)
+	require.Equal(t, int64(0), events[0].Count)
+	require.Equal(t, []int64{20, 20, 0, 0, 0, 0, 0, 0}, seriesCounts(tracker.Series(1))[52:])
+}
+
+func TestFrequencyTrackerForgetsEvictedClusters(t *testing.T) {
+	drain, err := NewDrain(WithMaxCluster(2))
+	require.NoError(t, err)
+	tracker, err := NewFrequencyTracker(NewTemplateMiner(drain, NewMemoryPersistence()))
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		_, err := tracker.AddLogLine(context.Background(), fmt.Sprintf("template%d", i))
+		require.NoError(t, err)
+	}
+
+	// series of evicted clusters are dropped once they outnumber twice the live clusters, without calling Advance
+	require.LessOrEqual(t, len(tracker.clusterSeries), 4)
+	require.Nil(t, tracker.Series(1))
+	require.NotNil(t, tracker.Series(10))
+}
+
+func TestNewFrequencyTrackerErrors(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence())
+
+	for _, option := range []frequencyOptionFn{WithBucketWidth(0), WithBucketCount(0), WithEWMAAlpha(0), WithEWMAAlpha(1.5), WithDeviationThreshold(0), WithBaselineBuckets(-1)} {
+		_, err := NewFrequencyTracker(miner, option)
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,132 @@
+package drain3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ContentField is the log format field holding the message which is mined
+const ContentField = "Content"
+
+var ErrLogFormatMismatch = errors.New("line does not match log format")
+
+var (
+	logFormatFieldRegex = regexp.MustCompile(`<[^<>]+>`)
+	fieldNameRegex      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	spaceRunRegex       = regexp.MustCompile(` +`)
+)
+
+// HeaderParser splits lines into the fields of a LogPAI style log format such as "<Date> <Time> <Level> <Component>: <Content>"
+type HeaderParser struct {
+	logFormat string
+	regex     *regexp.Regexp
+	fields    []string
+}
+
+func NewHeaderParser(logFormat string) (*HeaderParser, error) {
+	fields := []string{}
+	seenFields := map[string]bool{}
+	regex := strings.Builder{}
+	regex.WriteString("^")
+
+	writeSeparator := func(separator string) {
+		// any run of spaces in the format matches any run of whitespace in the line
+		regex.WriteString(spaceRunRegex.ReplaceAllString(regexp.QuoteMeta(separator), `\s+`))
+	}
+
+	position := 0
+	for _, loc := range logFormatFieldRegex.FindAllStringIndex(logFormat, -1) {
+		writeSeparator(logFormat[position:loc[0]])
+
+		field := logFormat[loc[0]+1 : loc[1]-1]
+		if !fieldNameRegex.MatchString(field) {
+			return nil, fmt.Errorf("invalid field name %q in log format", field)
+		} else if seenFields[field] {
+			return nil, fmt.Errorf("duplicated field %q in log format", field)
+		}
+		seenFields[field] = true
+		fields = append(fields, field)
+
+		regex.WriteString(fmt.Sprintf(`(?P<%s>.*?)`, field))
+		position = loc[1]
+	}
+	writeSeparator(logFormat[position:])
+	regex.WriteString("$")
+
+	if !seenFields[ContentField] {
+		return nil, fmt.Errorf("log format must contain the <%s> field", ContentField)
+	}
+
+	compiled, err := regexp.Compile(regex.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile log format regex: %w", err)
+	}
+
+	return &HeaderParser{
+		logFormat: logFormat,
+		regex:     compiled,
+		fields:    fields,
+	}, nil
+}
+
+// Parse returns the header fields of a line and its content, ErrLogFormatMismatch is returned for lines not following the format
+func (p *HeaderParser) Parse(line string) (map[string]string, string, error) {
+	match := p.regex.FindStringSubmatch(strings.TrimSpace(line))
+	if match == nil {
+		return nil, "", ErrLogFormatMismatch
+	}
+
+	header := map[string]string{}
+	content := ""
+	for i, name := range p.regex.SubexpNames() {
+		if i == 0 || name == "" {
+			continue
+		}
+
+		if name == ContentField {
+			content = match[i]
+		} else {
+			header[name] = match[i]
+		}
+	}
+
+	return header, content, nil
+}
+
+func (p *HeaderParser) Fields() []string {
+	return append([]string{}, p.fields...)
+}
+
+type LineResult struct {
+	*MiningResult
+	// header fields of the line, excluding its content
+	Header map[string]string
+}
+
+// AddLogLine mines only the content of a line, as split by the header parser of the miner.
+// the whole line is mined when no header parser is configured
+func (m *TemplateMiner) AddLogLine(ctx context.Context, line string) (*LineResult, error) {
+	header := map[string]string{}
+	content := line
+
+	if m.headerParser != nil {
+		var err error
+		header, content, err = m.headerParser.Parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse header: %w", err)
+		}
+	}
+
+	result, err := m.addLogMessage(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LineResult{
+		MiningResult: result,
+		Header:       header,
+	}, nil
+}
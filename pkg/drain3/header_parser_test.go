@@ -0,0 +1,73 @@
+package drain3
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHeaderParser(t *testing.T) {
+	parser, err := NewHeaderParser("<Date> <Time> <Level> [<Component>]: <Content>")
+	require.NoError(t, err)
+	require.Equal(t, []string{"Date", "Time", "Level", "Component", "Content"}, parser.Fields())
+
+	// runs of spaces in the line match a single space of the format
+	header, content, err := parser.Parse("  2024-01-01 00:00:00   INFO [kafka.log.Log]: Rolled new log segment at offset 42  ")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"Date":      "2024-01-01",
+		"Time":      "00:00:00",
+		"Level":     "INFO",
+		"Component": "kafka.log.Log",
+	}, header)
+	require.Equal(t, "Rolled new log segment at offset 42", content)
+
+	_, _, err = parser.Parse("Rolled new log segment at offset 42")
+	require.ErrorIs(t, err, ErrLogFormatMismatch)
+}
+
+func TestNewHeaderParserErrors(t *testing.T) {
+	for _, logFormat := range []string{
+		"<Date> <Level>",
+		"<Date> <Date> <Content>",
+		"<1Date> <Content>",
+		"<Level-Name> <Content>",
+	} {
+		_, err := NewHeaderParser(logFormat)
+		require.Error(t, err, logFormat)
+	}
+}
+
+func TestAddLogLine(t *testing.T) {
+	ctx := context.Background()
+	parser, err := NewHeaderParser("<Time> <Level>: <Content>")
+	require.NoError(t, err)
+
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithHeaderParser(parser))
+
+	// headers are left out of the mined message, so lines of different levels share a template
+	for _, line := range []string{"10:00:00 INFO: user 1 logged in", "10:00:01 WARN: user 2 logged in"} {
+		result, err := miner.AddLogLine(ctx, line)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), result.Cluster.ClusterId)
+	}
+
+	result, err := miner.AddLogLine(ctx, "10:00:02 ERROR: user 3 logged in")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"Time": "10:00:02", "Level": "ERROR"}, result.Header)
+	require.Equal(t, "user <*> logged in", result.Template)
+
+	_, err = miner.AddLogLine(ctx, "no header at all")
+	require.ErrorIs(t, err, ErrLogFormatMismatch)
+
+	// without a header parser the whole line is mined
+	drain, err = NewDrain()
+	require.NoError(t, err)
+	miner = NewTemplateMiner(drain, NewMemoryPersistence())
+	result, err = miner.AddLogLine(ctx, "10:00:00 INFO: user 1 logged in")
+	require.NoError(t, err)
+	require.Empty(t, result.Header)
+	require.Equal(t, "10:00:00 INFO: user 1 logged in", result.Template)
+}
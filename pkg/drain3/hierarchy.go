@@ -0,0 +1,109 @@
+package drain3
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TemplateGroup is a node of a template hierarchy, it groups clusters whose templates are similar at its threshold
+type TemplateGroup struct {
+	// template generalizing the templates of every cluster of the group
+	LogTemplateTokens []string
+	SimTh             float64
+	Size              int64
+	// partition of every cluster of the group, clusters of different partitions are never grouped like they are never merged
+	Partition string
+	// every cluster under the group, including the clusters of its children
+	Clusters []*LogCluster
+	// groups at the next higher threshold, none at the highest threshold
+	Children []*TemplateGroup
+}
+
+func (g *TemplateGroup) GetTemplate() string {
+	return strings.Join(g.LogTemplateTokens, " ")
+}
+
+// BuildHierarchy groups the current clusters at each of the similarity thresholds, from coarse event families at the lowest threshold
+// down to groups of near identical templates at the highest one. it returns the groups of the lowest threshold, the largest first
+func (d *Drain) BuildHierarchy(simThs ...float64) ([]*TemplateGroup, error) {
+	if len(simThs) == 0 {
+		return nil, errors.New("at least one similarity threshold is required")
+	}
+
+	sortedSimThs := append([]float64{}, simThs...)
+	sort.Float64s(sortedSimThs)
+	for _, simTh := range sortedSimThs {
+		if simTh <= 0 || simTh > 1 {
+			return nil, fmt.Errorf("similarity threshold %v out of (0, 1]", simTh)
+		}
+	}
+
+	return d.groupClusters(d.GetClusters(), sortedSimThs)
+}
+
+func (d *Drain) groupClusters(clusters []*LogCluster, simThs []float64) ([]*TemplateGroup, error) {
+	// larger clusters are grouped first so that they shape the group templates
+	clusters = append([]*LogCluster{}, clusters...)
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Size != clusters[j].Size {
+			return clusters[i].Size > clusters[j].Size
+		}
+		return clusters[i].ClusterId < clusters[j].ClusterId
+	})
+
+	simTh := simThs[0]
+	groups := []*TemplateGroup{}
+	for _, cluster := range clusters {
+		var bestGroup *TemplateGroup
+		bestSim := float64(-1)
+		for _, group := range groups {
+			if group.Partition != cluster.Partition {
+				continue
+			}
+
+			sim, err := d.getTemplateSimilarity(group.LogTemplateTokens, cluster.LogTemplateTokens)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get template similarity: %w", err)
+			}
+			if sim >= simTh && sim > bestSim {
+				bestGroup = group
+				bestSim = sim
+			}
+		}
+
+		if bestGroup == nil {
+			groups = append(groups, &TemplateGroup{
+				LogTemplateTokens: append([]string{}, cluster.LogTemplateTokens...),
+				SimTh:             simTh,
+				Size:              cluster.Size,
+				Partition:         cluster.Partition,
+				Clusters:          []*LogCluster{cluster},
+			})
+			continue
+		}
+
+		templateTokens, err := d.mergeTemplates(bestGroup.LogTemplateTokens, cluster.LogTemplateTokens)
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge templates: %w", err)
+		}
+		bestGroup.LogTemplateTokens = templateTokens
+		bestGroup.Size += cluster.Size
+		bestGroup.Clusters = append(bestGroup.Clusters, cluster)
+	}
+
+	if len(simThs) > 1 {
+		for _, group := range groups {
+			children, err := d.groupClusters(group.Clusters, simThs[1:])
+			if err != nil {
+				return nil, err
+			}
+			group.Children = children
+		}
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Size > groups[j].Size })
+
+	return groups, nil
+}
@@ -0,0 +1,76 @@
+package drain3
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBuildHierarchy(t *testing.T) {
+	drain, err := NewDrain(WithSimTh(0.9))
+	require.NoError(t, err)
+
+	for _, log := range []string{
+		"user alice logged in from web",
+		"user alice logged in from web",
+		"user bob logged out from web",
+		"user carol logged out from app",
+		"user dave logged out from web",
+		"disk full",
+	} {
+		_, _, err := drain.AddLogMessage(log)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 5, drain.IdToCluster.Len())
+
+	_, err = drain.BuildHierarchy()
+	require.Error(t, err)
+	_, err = drain.BuildHierarchy(1.5)
+	require.Error(t, err)
+
+	groups, err := drain.BuildHierarchy(0.8, 0.4)
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+
+	require.Equal(t, "user <*> logged <*> from <*>", groups[0].GetTemplate())
+	require.Equal(t, 0.4, groups[0].SimTh)
+	require.Equal(t, int64(5), groups[0].Size)
+	require.Len(t, groups[0].Clusters, 4)
+	require.Equal(t, "disk full", groups[1].GetTemplate())
+	require.Len(t, groups[1].Children, 1)
+
+	children := groups[0].Children
+	require.Len(t, children, 3)
+	require.Equal(t, "user alice logged in from web", children[0].GetTemplate())
+	require.Equal(t, "user <*> logged out from web", children[1].GetTemplate())
+	require.Equal(t, int64(2), children[1].Size)
+	require.Equal(t, "user carol logged out from app", children[2].GetTemplate())
+	for _, child := range children {
+		require.Equal(t, 0.8, child.SimTh)
+		require.Empty(t, child.Children)
+	}
+}
+
+func TestBuildHierarchyPartitions(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithJSONKeysInTemplate())
+
+	for _, line := range []string{
+		`{"msg":"request done","user":"alice"}`,
+		`{"msg":"request done","user":"bob"}`,
+		`{"msg":"request done","host":"a"}`,
+	} {
+		_, err := miner.AddJSONLogMessage(context.Background(), line)
+		require.NoError(t, err)
+	}
+
+	// the same template in two key sets makes two groups
+	groups, err := drain.BuildHierarchy(0.4)
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	require.Equal(t, `["user"]`, groups[0].Partition)
+	require.Equal(t, int64(2), groups[0].Size)
+	require.Equal(t, `["host"]`, groups[1].Partition)
+	require.Equal(t, "request done", groups[1].GetTemplate())
+}
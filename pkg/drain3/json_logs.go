@@ -0,0 +1,135 @@
+package drain3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type JSONLogResult struct {
+	*MiningResult
+	// parameters of the message, followed by every other field named after its key
+	Parameters []*ExtractedParameter
+}
+
+// AddJSONLogMessage mines the message field of a log written as a json object
+func (m *TemplateMiner) AddJSONLogMessage(ctx context.Context, line string) (*JSONLogResult, error) {
+	jsonLog, err := m.parseJSONLog(line)
+	if err != nil {
+		return nil, err
+	}
+
+	result, parameters, err := m.mine(ctx, jsonLog.partition, jsonLog.message, true)
+	if err != nil {
+		return nil, err
+	}
+	if parameters == nil {
+		parameters = []*ExtractedParameter{}
+	}
+
+	for _, key := range jsonLog.keys {
+		value, err := jsonFieldToString(jsonLog.fields[key])
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %q field: %w", key, err)
+		}
+
+		parameter := &ExtractedParameter{
+			Value: value,
+			Name:  key,
+		}
+		m.inferParamType(parameter, "")
+		parameters = append(parameters, parameter)
+	}
+
+	return &JSONLogResult{
+		MiningResult: result,
+		Parameters:   parameters,
+	}, nil
+}
+
+// MatchJSONLogMessage is Match for the message field of a json log, among the clusters of its key set
+// when WithJSONKeysInTemplate is set
+func (m *TemplateMiner) MatchJSONLogMessage(line string, strategy SearchStrategy) (*LogCluster, error) {
+	jsonLog, err := m.parseJSONLog(line)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.drain.match(jsonLog.partition, jsonLog.message, strategy)
+}
+
+type jsonLog struct {
+	fields  map[string]any
+	message string
+	// sorted keys of the fields other than the message
+	keys      []string
+	partition string
+}
+
+func (m *TemplateMiner) parseJSONLog(line string) (*jsonLog, error) {
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+
+	var fields map[string]any
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal json log: %w", err)
+	} else if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("json log has trailing data")
+	}
+
+	rawMessage, exist := fields[m.jsonMessageField]
+	if !exist {
+		return nil, fmt.Errorf("json log has no %q field", m.jsonMessageField)
+	}
+
+	message, err := jsonFieldToString(rawMessage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert %q field: %w", m.jsonMessageField, err)
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		if key != m.jsonMessageField {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	// the key set partitions the clusters outside of the tokens, so that delimiters or digits in keys cannot alter it
+	partition := ""
+	if m.jsonKeysInTemplate {
+		rawKeys, err := json.Marshal(keys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal keys: %w", err)
+		}
+		partition = string(rawKeys)
+	}
+
+	return &jsonLog{
+		fields:    fields,
+		message:   message,
+		keys:      keys,
+		partition: partition,
+	}, nil
+}
+
+// jsonFieldToString returns strings as is and any other value as json
+func jsonFieldToString(value any) (string, error) {
+	if str, ok := value.(string); ok {
+		return str, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
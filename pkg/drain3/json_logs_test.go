@@ -0,0 +1,135 @@
+package drain3
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestAddJSONLogMessage(t *testing.T) {
+	ctx := context.Background()
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithParamTypeInference())
+
+	_, err = miner.AddJSONLogMessage(ctx, `{"msg":"user 1 logged in","level":"info"}`)
+	require.NoError(t, err)
+	result, err := miner.AddJSONLogMessage(ctx, `{"msg":"user 2 logged in","level":"info","latency":12,"tags":["a","<b>"]}`)
+	require.NoError(t, err)
+	require.Equal(t, "user <*> logged in", result.Template)
+	require.Empty(t, result.Cluster.Partition)
+
+	// parameters of the message come first, then the other fields by key
+	values := map[string]string{}
+	for _, parameter := range result.Parameters[1:] {
+		values[parameter.Name] = parameter.Value
+	}
+	require.Equal(t, "2", result.Parameters[0].Value)
+	require.Equal(t, map[string]string{"latency": "12", "level": "info", "tags": `["a","<b>"]`}, values)
+	require.Equal(t, ParamTypeInt, result.Parameters[1].Type)
+	require.Equal(t, "latency", result.Parameters[1].Name)
+
+	for _, line := range []string{`not json`, `{"message":"user 3 logged in"}`, `["msg"]`, `{"msg":"x"} garbage`, `{"msg":"x"}{}`} {
+		_, err := miner.AddJSONLogMessage(ctx, line)
+		require.Error(t, err, line)
+	}
+
+	// the message field can be configured and need not be a string
+	miner = NewTemplateMiner(drain, NewMemoryPersistence(), WithJSONMessageField("message"))
+	result, err = miner.AddJSONLogMessage(ctx, `{"message":42}`)
+	require.NoError(t, err)
+	require.Equal(t, "42", result.Template)
+}
+
+func TestAddJSONLogMessageKeySets(t *testing.T) {
+	ctx := context.Background()
+	drain, err := NewDrain(WithExtraDelimiter([]string{"_"}))
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithJSONKeysInTemplate())
+
+	clusterIds := map[string]int64{}
+	for _, line := range []string{
+		`{"msg":"request done","user_id":1}`,
+		`{"msg":"request done","user_id":2}`,
+		`{"msg":"request done","user_name":"bob"}`,
+		`{"msg":"request done","shard1":"a"}`,
+		`{"msg":"request done","shard2":"a"}`,
+		`{"msg":"request done"}`,
+	} {
+		result, err := miner.AddJSONLogMessage(ctx, line)
+		require.NoError(t, err)
+		require.Equal(t, "request done", result.Template)
+		clusterIds[result.Cluster.Partition] = result.Cluster.ClusterId
+	}
+
+	// keys differing only after a delimiter or by a digit are distinct key sets
+	require.Equal(t, map[string]int64{
+		`["user_id"]`:   1,
+		`["user_name"]`: 2,
+		`["shard1"]`:    3,
+		`["shard2"]`:    4,
+		`[]`:            5,
+	}, clusterIds)
+
+	// messages of a key set are still clustered together
+	result, err := miner.AddJSONLogMessage(ctx, `{"user_id":3,"msg":"request failed"}`)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), result.Cluster.ClusterId)
+	require.Equal(t, "request <*>", result.Template)
+	require.Equal(t, "failed", result.Parameters[0].Value)
+	require.Equal(t, "user_id", result.Parameters[1].Name)
+	require.True(t, drain.CheckConsistency().IsConsistent())
+
+	// clusters of different key sets are never merged
+	_, err = drain.MergeClusters(1, 2)
+	require.Error(t, err)
+	merged, err := drain.MergeSimilarClusters(0.1)
+	require.NoError(t, err)
+	require.Empty(t, merged)
+
+	// partitions are kept in snapshots, including when the tree is rebuilt from the clusters
+	drain.RebuildTreeOnLoad = true
+	for _, codec := range []SnapshotCodec{NewJSONCodec(), NewBinaryCodec()} {
+		state, err := codec.Marshal(drain)
+		require.NoError(t, err)
+		loaded, err := codec.Unmarshal(state)
+		require.NoError(t, err)
+
+		loadedMiner := NewTemplateMiner(loaded, NewMemoryPersistence(), WithJSONKeysInTemplate())
+		result, err := loadedMiner.AddJSONLogMessage(ctx, `{"msg":"request done","user_name":"carol"}`)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), result.Cluster.ClusterId)
+		require.Equal(t, ClusterUpdateTypeNone, result.UpdateType)
+	}
+}
+
+func TestMatchJSONLogMessage(t *testing.T) {
+	ctx := context.Background()
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithJSONKeysInTemplate())
+
+	for _, line := range []string{`{"msg":"user 1 logged in","lvl":"info"}`, `{"msg":"user 2 logged in","lvl":"info"}`} {
+		_, err := miner.AddJSONLogMessage(ctx, line)
+		require.NoError(t, err)
+	}
+
+	// the clusters of a key set are only matched by logs of the same key set
+	for _, strategy := range []SearchStrategy{SearchStrategyNever, SearchStrategyFallback, SearchStrategyAlways} {
+		cluster, err := miner.MatchJSONLogMessage(`{"lvl":"warn","msg":"user 3 logged in"} `, strategy)
+		require.NoError(t, err)
+		require.NotNil(t, cluster)
+		require.Equal(t, int64(1), cluster.ClusterId)
+
+		cluster, err = miner.MatchJSONLogMessage(`{"msg":"user 3 logged in"}`, strategy)
+		require.NoError(t, err)
+		require.Nil(t, cluster)
+
+		cluster, err = miner.Match("user 3 logged in", strategy)
+		require.NoError(t, err)
+		require.Nil(t, cluster)
+	}
+
+	_, err = miner.MatchJSONLogMessage(`{"msg":"user 3 logged in"} garbage`, SearchStrategyNever)
+	require.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package drain3
+
+import "strings"
+
+// splitKeyValue splits a key=value token on its first '='
+func splitKeyValue(token string) (string, string, bool) {
+	index := strings.Index(token, "=")
+	if index <= 0 {
+		return "", "", false
+	}
+
+	return token[:index], token[index+1:], true
+}
+
+// treeKey returns the key under which a token is stored in the prefix tree.
+// with key=value tokens only the key is relevant, so every value of a key shares the same node.
+// variable parameters share the node of regular parameters
+func (d *Drain) treeKey(token string) string {
+	if d.isVariableParam(token) {
+		return d.ParamStr
+	}
+
+	if !d.KeyValueTokens {
+		return token
+	}
+
+	key, _, isKeyValue := splitKeyValue(token)
+	if !isKeyValue {
+		return token
+	}
+
+	return key + "=" + d.ParamStr
+}
+
+// paramTokenFor returns the template token replacing two different tokens
+func (d *Drain) paramTokenFor(token1, token2 string) string {
+	if !d.KeyValueTokens {
+		return d.ParamStr
+	}
+
+	key1, _, isKeyValue1 := splitKeyValue(token1)
+	key2, _, isKeyValue2 := splitKeyValue(token2)
+	if isKeyValue1 && isKeyValue2 && key1 == key2 {
+		return key1 + "=" + d.ParamStr
+	}
+
+	return d.ParamStr
+}
+
+type templateParameter struct {
+	// name of the parameter, empty for unnamed parameters
+	name string
+	// index of the template token holding the parameter
+	tokenIndex int
+	// template token following a parameter which is a whole token, e.g. a unit
+	nextToken string
+}
+
+// getTemplateParameters returns every parameter of a template in order
+func (d *Drain) getTemplateParameters(logTemplate string) []*templateParameter {
+	parameters := []*templateParameter{}
+	tokens := strings.Split(logTemplate, " ")
+	for tokenIndex, token := range tokens {
+		count := strings.Count(token, d.ParamStr)
+		if d.isVariableParam(token) {
+			count = 1
+		}
+		if count == 0 {
+			continue
+		}
+
+		name := ""
+		if key, value, isKeyValue := splitKeyValue(token); d.KeyValueTokens && isKeyValue && value == d.ParamStr {
+			name = key
+		}
+
+		nextToken := ""
+		if d.isParam(token) && tokenIndex+1 < len(tokens) {
+			nextToken = tokens[tokenIndex+1]
+		}
+
+		for i := 0; i < count; i++ {
+			parameters = append(parameters, &templateParameter{
+				name:       name,
+				tokenIndex: tokenIndex,
+				nextToken:  nextToken,
+			})
+		}
+	}
+	return parameters
+}
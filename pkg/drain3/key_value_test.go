@@ -0,0 +1,88 @@
+package drain3
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestKeyValueSimilarity(t *testing.T) {
+	drain, err := NewDrain(WithKeyValueTokens())
+	require.NoError(t, err)
+
+	// a shared key counts as a matching token on top of its value
+	sim, paramCount, err := drain.getSeqDistance([]string{"user=alice", "action=login", "ok"}, []string{"user=bob", "action=login", "ok"}, false)
+	require.NoError(t, err)
+	require.InDelta(t, 4.0/5, sim, 0.0001)
+	require.Equal(t, int64(0), paramCount)
+
+	sim, paramCount, err = drain.getSeqDistance([]string{"user=<*>", "done"}, []string{"user=bob", "done"}, false)
+	require.NoError(t, err)
+	require.InDelta(t, 2.0/3, sim, 0.0001)
+	require.Equal(t, int64(1), paramCount)
+
+	sim, _, err = drain.getSeqDistance([]string{"user=<*>", "done"}, []string{"user=bob", "done"}, true)
+	require.NoError(t, err)
+	require.InDelta(t, 1, sim, 0.0001)
+
+	// different keys do not match at all
+	sim, _, err = drain.getSeqDistance([]string{"user=bob", "done"}, []string{"host=bob", "done"}, false)
+	require.NoError(t, err)
+	require.InDelta(t, 1.0/2, sim, 0.0001)
+
+	drain, err = NewDrain()
+	require.NoError(t, err)
+	sim, _, err = drain.getSeqDistance([]string{"user=alice", "action=login", "ok"}, []string{"user=bob", "action=login", "ok"}, false)
+	require.NoError(t, err)
+	require.InDelta(t, 2.0/3, sim, 0.0001)
+}
+
+func TestKeyValueTreeKey(t *testing.T) {
+	drain, err := NewDrain(WithKeyValueTokens())
+	require.NoError(t, err)
+
+	require.Equal(t, "user=<*>", drain.treeKey("user=bob"))
+	require.Equal(t, "user=<*>", drain.treeKey("user=a=b"))
+	require.Equal(t, "=bob", drain.treeKey("=bob"))
+	require.Equal(t, "plain", drain.treeKey("plain"))
+
+	drain, err = NewDrain()
+	require.NoError(t, err)
+	require.Equal(t, "user=bob", drain.treeKey("user=bob"))
+}
+
+func TestKeyValueTemplates(t *testing.T) {
+	ctx := context.Background()
+	drain, err := NewDrain(WithKeyValueTokens())
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence())
+
+	// every value of a key is routed to the same node, so the messages share a cluster despite their first token
+	for _, log := range []string{"user=alice logged in", "user=bob logged in"} {
+		_, _, _, _, err := miner.AddLogMessage(ctx, log)
+		require.NoError(t, err)
+	}
+	_, cluster, template, _, err := miner.AddLogMessage(ctx, "user=carol logged in")
+	require.NoError(t, err)
+	require.Equal(t, "user=<*> logged in", template)
+	require.Equal(t, int64(3), cluster.Size)
+	require.Len(t, drain.RootNode.KeyToChildNode["3"].KeyToChildNode, 1)
+
+	parameters := miner.ExtractParameters(template, "user=dave logged in")
+	require.Len(t, parameters, 1)
+	require.Equal(t, "dave", parameters[0].Value)
+	require.Equal(t, "user", parameters[0].Name)
+
+	// tokens of different keys are replaced by an unnamed parameter
+	require.Equal(t, "user=<*>", drain.paramTokenFor("user=a", "user=b"))
+	require.Equal(t, "<*>", drain.paramTokenFor("user=a", "host=a"))
+
+	// without key=value tokens the first tokens lead to different leaves
+	drain, err = NewDrain()
+	require.NoError(t, err)
+	for _, log := range []string{"user=alice logged in", "user=bob logged in"} {
+		_, updateType, err := drain.AddLogMessage(log)
+		require.NoError(t, err)
+		require.Equal(t, ClusterUpdateTypeCreated, updateType)
+	}
+}
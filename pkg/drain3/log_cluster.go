@@ -3,12 +3,20 @@ package drain3
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 type LogCluster struct {
 	ClusterId         int64
 	LogTemplateTokens []string
 	Size              int64
+	LastAccessTime    time.Time
+	Samples           []string // distinct recent messages, from oldest to newest
+	// types learned for the parameters of the template, keyed by the index of the template token holding them
+	ParamSlots map[int]*ParamSlot
+	// messages are only matched against clusters of their partition, which is empty unless the miner partitions them,
+	// e.g. json logs by their key set with WithJSONKeysInTemplate
+	Partition string
 }
 
 func NewLogCluster(clusterId int64, logTemplateTokens []string) *LogCluster {
@@ -26,3 +34,21 @@ func (l *LogCluster) GetTemplate() string {
 func (l *LogCluster) String() string {
 	return fmt.Sprintf("ID=%-5d : size=%-10d: %s", l.ClusterId, l.Size, l.GetTemplate())
 }
+
+func (l *LogCluster) addSample(content string, maxSamples int) {
+	if maxSamples <= 0 {
+		return
+	}
+
+	for i, sample := range l.Samples {
+		if sample == content {
+			l.Samples = append(l.Samples[:i], l.Samples[i+1:]...)
+			break
+		}
+	}
+
+	l.Samples = append(l.Samples, content)
+	if len(l.Samples) > maxSamples {
+		l.Samples = l.Samples[len(l.Samples)-maxSamples:]
+	}
+}
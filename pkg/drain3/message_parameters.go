@@ -0,0 +1,91 @@
+package drain3
+
+import "strings"
+
+// messageParameters returns the parameters of a message just mined into a cluster from its tokens aligned against the template.
+// ExtractParameters is used when the tokens do not align, e.g. for templates with several parameters in a token
+func (m *TemplateMiner) messageParameters(cluster *LogCluster, content string, tokens []string) []*ExtractedParameter {
+	values, ok := m.drain.alignParameterValues(cluster.LogTemplateTokens, tokens)
+	if !ok {
+		return m.ExtractParameters(cluster.GetTemplate(), content)
+	}
+
+	templateParameters := m.templateParameters(cluster.GetTemplate())
+	parameters := make([]*ExtractedParameter, 0, len(templateParameters))
+	for _, templateParameter := range templateParameters {
+		templateToken := cluster.LogTemplateTokens[templateParameter.tokenIndex]
+		maskToken := m.drain.ParamStr
+		if m.drain.isVariableParam(templateToken) {
+			maskToken = templateToken
+		}
+
+		parameter := &ExtractedParameter{
+			Value:    values[templateParameter.tokenIndex],
+			MaskName: strings.TrimSuffix(strings.TrimPrefix(maskToken, "<"), ">"),
+			Name:     templateParameter.name,
+		}
+		m.inferParamType(parameter, templateParameter.nextToken)
+		parameters = append(parameters, parameter)
+	}
+
+	return parameters
+}
+
+// alignParameterValues returns the value of every template token holding a parameter, keyed by token index.
+// false is returned when a literal does not match, a token holds several parameters or a parameter would be empty,
+// which the extraction regex does not accept
+func (d *Drain) alignParameterValues(templateTokens, tokens []string) (map[int]string, bool) {
+	values := map[int]string{}
+
+	if d.hasVariableParam(templateTokens) {
+		absorbed := map[int][]string{}
+		for _, step := range d.alignTokens(templateTokens, tokens, true).steps {
+			switch step.op {
+			case alignmentOpParam:
+				if tokens[step.messageIndex] == "" {
+					return nil, false
+				}
+				values[step.templateIndex] = tokens[step.messageIndex]
+			case alignmentOpAbsorb:
+				absorbed[step.templateIndex] = append(absorbed[step.templateIndex], tokens[step.messageIndex])
+			case alignmentOpSkipTemplate:
+				if !d.isVariableParam(templateTokens[step.templateIndex]) {
+					return nil, false
+				}
+			case alignmentOpSkipMessage:
+				return nil, false
+			}
+		}
+
+		for index, templateToken := range templateTokens {
+			if d.isVariableParam(templateToken) {
+				values[index] = strings.Join(absorbed[index], " ")
+			}
+		}
+		return values, true
+	}
+
+	if len(templateTokens) != len(tokens) {
+		return nil, false
+	}
+
+	for index, templateToken := range templateTokens {
+		token := tokens[index]
+		switch strings.Count(templateToken, d.ParamStr) {
+		case 0:
+			if token != templateToken {
+				return nil, false
+			}
+		case 1:
+			prefix, suffix, _ := strings.Cut(templateToken, d.ParamStr)
+			if len(token) <= len(prefix)+len(suffix) || !strings.HasPrefix(token, prefix) || !strings.HasSuffix(token, suffix) {
+				return nil, false
+			}
+			values[index] = token[len(prefix) : len(token)-len(suffix)]
+		default:
+			return nil, false
+		}
+	}
+
+	return values, true
+}
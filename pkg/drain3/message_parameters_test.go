@@ -0,0 +1,47 @@
+package drain3
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddLogMessageWithParameters(t *testing.T) {
+	drain, err := NewDrain(WithKeyValueTokens())
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithParamTypeInference())
+
+	result, err := miner.AddLogMessageWithParameters(context.Background(), "user=alice took 3 ms")
+	require.NoError(t, err)
+	require.Equal(t, ClusterUpdateTypeCreated, result.UpdateType)
+	require.Empty(t, result.Parameters)
+
+	result, err = miner.AddLogMessageWithParameters(context.Background(), "user=bob took 5 ms")
+	require.NoError(t, err)
+	require.Equal(t, ClusterUpdateTypeTemplateChanged, result.UpdateType)
+	require.Equal(t, "user=<*> took <*> ms", result.Template)
+	require.Equal(t, 1, result.ClusterCount)
+	require.Equal(t, []*ExtractedParameter{
+		{Value: "bob", MaskName: "*", Name: "user", Type: ParamTypeString, TypedValue: "bob"},
+		{Value: "5", MaskName: "*", Type: ParamTypeDuration, TypedValue: 5 * time.Millisecond},
+	}, result.Parameters)
+}
+
+func TestAddLogMessageWithParametersMatchesExtractParameters(t *testing.T) {
+	for _, options := range [][]optionFn{{}, {WithExtraDelimiter([]string{",", ";"})}, {WithKeyValueTokens()}, {WithVariableLength(3)}} {
+		drain, err := NewDrain(options...)
+		require.NoError(t, err)
+		miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithParamTypeInference())
+
+		lines := append(generateLines(rand.New(rand.NewSource(3)), 300, tokenSeparators(drain.ExtraDelimiters)), kafkaLogs...)
+		for _, line := range lines {
+			result, err := miner.AddLogMessageWithParameters(context.Background(), line)
+			require.NoError(t, err)
+			// whitespace around the message is ignored as when mining it
+			require.Equal(t, miner.ExtractParameters(result.Template, strings.TrimSpace(line)), result.Parameters, line)
+		}
+	}
+}
@@ -2,43 +2,220 @@ package drain3
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	lru "github.com/hashicorp/golang-lru/v2"
 	"regexp"
 	"strings"
 	"time"
 )
 
+var escapedSpaceRegex = regexp.MustCompile(`\\ `)
+
 type TemplateMiner struct {
 	drain        *Drain
 	persistence  PersistenceHandler
+	codec        SnapshotCodec
+	headerParser *HeaderParser
 	lastSaveTime time.Time
+
+	jsonMessageField   string
+	jsonKeysInTemplate bool
+	inferParamTypes    bool
+	learnParamTypes    bool
+	paramSlotStatsTopK int
+	recordLayouts      bool
+
+	// compiled extraction regexes, see ExtractParameters
+	matcherCacheSize int
+	matchers         *lru.Cache[string, *templateMatcher]
+	delimiterRegexes []*regexp.Regexp
+}
+
+type minerOptionFn func(*TemplateMiner)
+
+func WithSnapshotCodec(codec SnapshotCodec) minerOptionFn {
+	return func(miner *TemplateMiner) {
+		miner.codec = codec
+	}
+}
+
+// WithHeaderParser makes AddLogLine mine only the content field of each line
+func WithHeaderParser(headerParser *HeaderParser) minerOptionFn {
+	return func(miner *TemplateMiner) {
+		miner.headerParser = headerParser
+	}
+}
+
+// WithJSONMessageField sets the field AddJSONLogMessage mines, "msg" by default
+func WithJSONMessageField(field string) minerOptionFn {
+	return func(miner *TemplateMiner) {
+		miner.jsonMessageField = field
+	}
+}
+
+// WithJSONKeysInTemplate partitions the clusters of json logs by the sorted set of their other keys,
+// so that messages logged with different fields do not share a cluster. the partition is kept in LogCluster.Partition, not in the template
+func WithJSONKeysInTemplate() minerOptionFn {
+	return func(miner *TemplateMiner) {
+		miner.jsonKeysInTemplate = true
+	}
+}
+
+// WithParamTypeInference sets the type and typed value of the parameters returned by ExtractParameters, AddLogMessageWithParameters
+// and AddJSONLogMessage. parameters are plain strings otherwise, as inference tries every type on every value
+func WithParamTypeInference() minerOptionFn {
+	return func(miner *TemplateMiner) {
+		miner.inferParamTypes = true
+	}
+}
+
+// WithParamTypeLearning counts the types of the parameters of every added message in the parameter slots of its cluster,
+// which ExtractClusterParameters uses to convert values to the dominant type of their slot. it implies WithParamTypeInference
+func WithParamTypeLearning() minerOptionFn {
+	return func(miner *TemplateMiner) {
+		miner.learnParamTypes = true
+	}
+}
+
+// WithParamSlotStats tracks the approximate cardinality, the most frequent values (with topK counters) and the numeric range
+// of the values of every parameter slot of the cluster of each added message.
+// only the values of messages added while a token is a parameter are counted: values seen before the token was generalised are not
+func WithParamSlotStats(topK int) minerOptionFn {
+	return func(miner *TemplateMiner) {
+		miner.paramSlotStatsTopK = topK
+	}
+}
+
+// WithMessageLayouts records the layout of the content mined by AddLogLine, AddJSONLogMessage, AddLogRecord or AddLogMessageWithParameters in their results,
+// so that Render can reproduce the content exactly
+func WithMessageLayouts() minerOptionFn {
+	return func(miner *TemplateMiner) {
+		miner.recordLayouts = true
+	}
 }
 
-func NewTemplateMiner(drain *Drain, persistence PersistenceHandler) *TemplateMiner {
-	return &TemplateMiner{
+// WithExtractionCacheSize sets how many templates ExtractParameters keeps compiled matchers for, 1000 by default.
+// a size of 0 disables the cache
+func WithExtractionCacheSize(size int) minerOptionFn {
+	return func(miner *TemplateMiner) {
+		miner.matcherCacheSize = size
+	}
+}
+
+func NewTemplateMiner(drain *Drain, persistence PersistenceHandler, options ...minerOptionFn) *TemplateMiner {
+	miner := &TemplateMiner{
 		drain:        drain,
 		persistence:  persistence,
+		codec:        NewJSONCodec(),
 		lastSaveTime: time.Now(),
+
+		jsonMessageField: "msg",
+		matcherCacheSize: 1000,
+	}
+
+	for _, option := range options {
+		option(miner)
 	}
+
+	if miner.matcherCacheSize > 0 {
+		// lru.New fails only for non positive sizes
+		miner.matchers, _ = lru.New[string, *templateMatcher](miner.matcherCacheSize)
+	}
+	miner.compileExtraDelimiters()
+
+	return miner
 }
 
 func (m *TemplateMiner) AddLogMessage(ctx context.Context, content string) (ClusterUpdateType, *LogCluster, string, int, error) {
-	logCluster, updateType, err := m.drain.AddLogMessage(content)
+	result, _, err := m.mine(ctx, "", content, false)
 	if err != nil {
 		return ClusterUpdateTypeNone, nil, "", 0, err
 	}
 
-	templateMined := logCluster.GetTemplate()
-	clusterCount := len(m.drain.IdToCluster.Keys())
+	return result.UpdateType, result.Cluster, result.Template, result.ClusterCount, nil
+}
+
+// MiningResult holds the values TemplateMiner.AddLogMessage returns for a single message
+type MiningResult struct {
+	UpdateType   ClusterUpdateType
+	Cluster      *LogCluster
+	Template     string
+	ClusterCount int
+	// layout of the message, only recorded with WithMessageLayouts
+	Layout *MessageLayout
+}
+
+type ParametersResult struct {
+	*MiningResult
+	// parameters of the message in the order of the template, nil when the message does not match the template
+	Parameters []*ExtractedParameter
+}
+
+// AddLogMessageWithParameters adds a message and returns its parameters in the same pass,
+// as ExtractParameters would return them for the mined template and the message without its surrounding whitespace.
+// parameters are taken from the tokens of the message aligned against the template instead of tokenising and matching the message again
+func (m *TemplateMiner) AddLogMessageWithParameters(ctx context.Context, content string) (*ParametersResult, error) {
+	result, parameters, err := m.mine(ctx, "", content, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ParametersResult{
+		MiningResult: result,
+		Parameters:   parameters,
+	}, nil
+}
+
+func (m *TemplateMiner) addLogMessage(ctx context.Context, content string) (*MiningResult, error) {
+	result, _, err := m.mine(ctx, "", content, false)
+	return result, err
+}
 
-	if updateType != ClusterUpdateTypeNone {
+// mine adds a message to the drain, its parameters are extracted when asked to or when parameter slots are observed.
+// the state is saved when a template was created or changed
+func (m *TemplateMiner) mine(ctx context.Context, partition, content string, extractParameters bool) (*MiningResult, []*ExtractedParameter, error) {
+	result, parameters, err := m.mineWithoutSave(partition, content, extractParameters)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if result.UpdateType != ClusterUpdateTypeNone {
 		if err := m.SaveState(ctx); err != nil {
-			return ClusterUpdateTypeNone, nil, "", 0, fmt.Errorf("failed to save state: %w", err)
+			return nil, nil, fmt.Errorf("failed to save state: %w", err)
 		}
 	}
 
-	return updateType, logCluster, templateMined, clusterCount, nil
+	return result, parameters, nil
+}
+
+// mineWithoutSave is mine leaving the state to be saved by the caller
+func (m *TemplateMiner) mineWithoutSave(partition, content string, extractParameters bool) (*MiningResult, []*ExtractedParameter, error) {
+	logCluster, updateType, tokens, err := m.drain.addLogMessage(partition, content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	observeParamSlots := m.learnParamTypes || m.paramSlotStatsTopK > 0
+
+	var parameters []*ExtractedParameter
+	if extractParameters || observeParamSlots {
+		parameters = m.messageParameters(logCluster, content, tokens)
+	}
+	if observeParamSlots {
+		m.observeParamSlots(logCluster, parameters)
+	}
+
+	result := &MiningResult{
+		UpdateType:   updateType,
+		Cluster:      logCluster,
+		Template:     logCluster.GetTemplate(),
+		ClusterCount: len(m.drain.IdToCluster.Keys()),
+	}
+	if m.recordLayouts {
+		result.Layout = m.drain.getMessageLayout(content)
+	}
+
+	return result, parameters, nil
 }
 
 func (m *TemplateMiner) Match(content string, strategy SearchStrategy) (*LogCluster, error) {
@@ -64,30 +241,34 @@ func (m *TemplateMiner) GetParameterList(logTemplate, logMessage string) []strin
 
 func (m *TemplateMiner) ExtractParameters(logTemplate, logMessage string) []*ExtractedParameter {
 	// extract parameters from a log message according to a provided template that was generated by calling `AddLogMessage()`
+	// the matcher of each template is compiled once and cached. messages with as many tokens as the template are matched token by token
 
-	for _, delimiter := range m.drain.ExtraDelimiters {
-		logMessage = regexp.MustCompile(delimiter).ReplaceAllString(logMessage, " ")
-	}
+	logMessage = m.replaceExtraDelimiters(logMessage)
 
-	templateRegex, paramGroupNameToMaskName := m.getTemplateParameterExtractionRegex(logTemplate)
-
-	// parameters are represented by specific named groups inside templateRegex
-	parameterMatch := regexp.MustCompile(templateRegex).FindStringSubmatch(logMessage)
+	matcher := m.getTemplateMatcher(logTemplate)
+	values, maskNames := matcher.match(logMessage)
 
 	// log template does not match template
-	if parameterMatch == nil {
+	if values == nil {
 		return nil
 	}
 
-	// create list of extracted parameters
+	// create list of extracted parameters, in the order they appear in the template
 	extractedParameters := []*ExtractedParameter{}
-	for groupName, parameter := range getNamedCaptures(regexp.MustCompile(templateRegex), parameterMatch) {
-		if maskName, ok := paramGroupNameToMaskName[groupName]; ok {
-			extractedParameters = append(extractedParameters, &ExtractedParameter{
-				Value:    parameter,
-				MaskName: maskName,
-			})
+	for i, value := range values {
+		extractedParameter := &ExtractedParameter{
+			Value:    value,
+			MaskName: maskNames[i],
+		}
+
+		unit := ""
+		if i < len(matcher.parameters) {
+			extractedParameter.Name = matcher.parameters[i].name
+			unit = matcher.parameters[i].nextToken
 		}
+		m.inferParamType(extractedParameter, unit)
+
+		extractedParameters = append(extractedParameters, extractedParameter)
 	}
 
 	return extractedParameters
@@ -107,7 +288,7 @@ func (m *TemplateMiner) getTemplateParameterExtractionRegex(logTemplate string)
 	createCaptureRegex := func(maskName string) string {
 		allowedPatterns := []string{}
 
-		if maskName == "*" {
+		if maskName == "*" || (m.drain.VariableLength && "<"+maskName+">" == m.drain.VariableParamStr) {
 			allowedPatterns = append(allowedPatterns, `.+?`)
 		}
 
@@ -127,6 +308,29 @@ func (m *TemplateMiner) getTemplateParameterExtractionRegex(logTemplate string)
 
 	templateRegex := regexp.QuoteMeta(logTemplate)
 
+	// a variable parameter captures any number of tokens. when it captures none, one of its surrounding spaces is gone too
+	if m.drain.VariableLength {
+		variableMaskName := strings.TrimSuffix(strings.TrimPrefix(m.drain.VariableParamStr, "<"), ">")
+		searchStr := regexp.QuoteMeta(m.drain.VariableParamStr)
+
+		for {
+			index := strings.Index(templateRegex, searchStr)
+			if index < 0 {
+				break
+			}
+
+			captureRegex := createCaptureRegex(variableMaskName)
+			before, after := templateRegex[:index], templateRegex[index+len(searchStr):]
+			if strings.HasSuffix(before, " ") {
+				templateRegex = strings.TrimSuffix(before, " ") + "(?: " + captureRegex + ")?" + after
+			} else if strings.HasPrefix(after, " ") {
+				templateRegex = before + "(?:" + captureRegex + " )?" + strings.TrimPrefix(after, " ")
+			} else {
+				templateRegex = before + "(?:" + captureRegex + ")?" + after
+			}
+		}
+	}
+
 	// replace each mask name with a proper regex that captures it
 	for maskName := range maskNames {
 		searchStr := "<" + regexp.QuoteMeta(maskName) + ">"
@@ -144,7 +348,7 @@ func (m *TemplateMiner) getTemplateParameterExtractionRegex(logTemplate string)
 	}
 
 	// match also messages with multiple spaces or other whitespace chars between tokens
-	templateRegex = regexp.MustCompile(`\\ `).ReplaceAllString(templateRegex, `\\s+`)
+	templateRegex = escapedSpaceRegex.ReplaceAllString(templateRegex, `\\s+`)
 	templateRegex = "^" + templateRegex + "$"
 
 	return templateRegex, paramGroupNameToMaskName
@@ -158,19 +362,36 @@ func (m *TemplateMiner) LoadState(ctx context.Context) error {
 		return fmt.Errorf("saved state not found")
 	}
 
-	var loadedDrain *Drain
-	if err := json.Unmarshal(state, &loadedDrain); err != nil {
+	// the codec is detected from the state so that switching codecs keeps previously saved states loadable
+	loadedDrain, err := detectSnapshotCodec(state).Unmarshal(state)
+	if err != nil {
 		return fmt.Errorf("failed to unmarshal state: %w", err)
 	}
 
+	// runtime settings are not part of the state and are kept from the current drain
+	if m.drain != nil {
+		loadedDrain.SimThStrategy = m.drain.SimThStrategy
+		loadedDrain.TokenWeigher = m.drain.TokenWeigher
+		loadedDrain.setTokenWeigherParams()
+	}
+	if strategy, ok := loadedDrain.SimThStrategy.(LearningSimThStrategy); ok {
+		strategy.SetLeafStats(loadedDrain.leafSimStats)
+		loadedDrain.leafSimStats = nil
+	}
+
 	m.drain = loadedDrain
+	// matchers and delimiters depend on the settings of the drain
+	if m.matchers != nil {
+		m.matchers.Purge()
+	}
+	m.compileExtraDelimiters()
 	return nil
 }
 
 func (m *TemplateMiner) SaveState(ctx context.Context) error {
-	state, err := json.Marshal(m.drain)
+	state, err := m.codec.Marshal(m.drain)
 	if err != nil {
-		return fmt.Errorf("failed to marshal json: %w", err)
+		return fmt.Errorf("failed to marshal state: %w", err)
 	}
 
 	if err := m.persistence.Save(ctx, state); err != nil {
@@ -180,19 +401,12 @@ func (m *TemplateMiner) SaveState(ctx context.Context) error {
 	return nil
 }
 
-func getNamedCaptures(regex *regexp.Regexp, match []string) map[string]string {
-	namedCaptures := make(map[string]string)
-
-	for i, name := range regex.SubexpNames() {
-		if i != 0 && name != "" {
-			namedCaptures[name] = match[i]
-		}
-	}
-
-	return namedCaptures
-}
-
 type ExtractedParameter struct {
 	Value    string
 	MaskName string
+	Name     string // name of the parameter when known, e.g. the key of a json field
+	// inferred type of the value and the value parsed as that type, e.g. a time.Duration for "3" followed by "ms".
+	// only inferred with WithParamTypeInference, the type is string and the typed value nil otherwise
+	Type       ParamType
+	TypedValue any
 }
@@ -0,0 +1,307 @@
+package drain3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// LogRecord is a logical log record made of a header line followed by continuation lines, e.g. stack frames
+type LogRecord struct {
+	Lines []string
+}
+
+func (r *LogRecord) Header() string {
+	return r.Lines[0]
+}
+
+func (r *LogRecord) Body() []string {
+	return r.Lines[1:]
+}
+
+func (r *LogRecord) Content() string {
+	return strings.Join(r.Lines, "\n")
+}
+
+type LineAggregator struct {
+	startPatterns        []*regexp.Regexp
+	continuationPatterns []*regexp.Regexp
+	maxLines             int
+	flushTimeout         time.Duration
+
+	pending      *LogRecord
+	lastLineTime time.Time
+	now          func() time.Time
+}
+
+type aggregatorOptions struct {
+	startPatterns        []string
+	continuationPatterns []string
+	maxLines             int
+	flushTimeout         time.Duration
+	now                  func() time.Time
+}
+
+type aggregatorOptionFn func(*aggregatorOptions)
+
+// WithStartPatterns makes every line matching one of the patterns start a new record,
+// any other line continues the pending record unless continuation patterns are configured as well
+func WithStartPatterns(patterns ...string) aggregatorOptionFn {
+	return func(options *aggregatorOptions) {
+		options.startPatterns = append(options.startPatterns, patterns...)
+	}
+}
+
+// WithContinuationPatterns makes every line matching one of the patterns continue the pending record
+func WithContinuationPatterns(patterns ...string) aggregatorOptionFn {
+	return func(options *aggregatorOptions) {
+		options.continuationPatterns = append(options.continuationPatterns, patterns...)
+	}
+}
+
+func WithMaxLines(maxLines int) aggregatorOptionFn {
+	return func(options *aggregatorOptions) {
+		options.maxLines = maxLines
+	}
+}
+
+// WithFlushTimeout emits a pending record once no line was added to it for the given duration
+func WithFlushTimeout(flushTimeout time.Duration) aggregatorOptionFn {
+	return func(options *aggregatorOptions) {
+		options.flushTimeout = flushTimeout
+	}
+}
+
+// WithAggregatorClock replaces time.Now as the clock the flush timeout is measured by
+func WithAggregatorClock(now func() time.Time) aggregatorOptionFn {
+	return func(options *aggregatorOptions) {
+		options.now = now
+	}
+}
+
+func NewLineAggregator(options ...aggregatorOptionFn) (*LineAggregator, error) {
+	aggregatorOptions := &aggregatorOptions{
+		maxLines:     500,
+		flushTimeout: 3 * time.Second,
+		now:          time.Now,
+	}
+
+	for _, option := range options {
+		option(aggregatorOptions)
+	}
+
+	if len(aggregatorOptions.startPatterns) == 0 && len(aggregatorOptions.continuationPatterns) == 0 {
+		return nil, errors.New("either start or continuation patterns are required")
+	} else if aggregatorOptions.maxLines < 1 {
+		return nil, errors.New("max lines must be at least 1")
+	}
+
+	startPatterns, err := compilePatterns(aggregatorOptions.startPatterns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile start patterns: %w", err)
+	}
+
+	continuationPatterns, err := compilePatterns(aggregatorOptions.continuationPatterns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile continuation patterns: %w", err)
+	}
+
+	return &LineAggregator{
+		startPatterns:        startPatterns,
+		continuationPatterns: continuationPatterns,
+		maxLines:             aggregatorOptions.maxLines,
+		flushTimeout:         aggregatorOptions.flushTimeout,
+		now:                  aggregatorOptions.now,
+	}, nil
+}
+
+// Add adds a line and returns the records it completed
+func (a *LineAggregator) Add(line string) []*LogRecord {
+	records := []*LogRecord{}
+
+	if record := a.FlushExpired(); record != nil {
+		records = append(records, record)
+	}
+
+	if a.pending != nil && !a.isContinuation(line) {
+		records = append(records, a.Flush())
+	}
+
+	if a.pending == nil {
+		a.pending = &LogRecord{Lines: []string{}}
+	}
+	a.pending.Lines = append(a.pending.Lines, line)
+	a.lastLineTime = a.now()
+
+	if len(a.pending.Lines) >= a.maxLines {
+		records = append(records, a.Flush())
+	}
+
+	return records
+}
+
+// FlushExpired returns the pending record if the flush timeout passed since its last line, nil otherwise.
+// it should be called periodically so that the last record of a quiet stream is not held back
+func (a *LineAggregator) FlushExpired() *LogRecord {
+	if a.pending == nil || a.flushTimeout <= 0 || a.now().Sub(a.lastLineTime) < a.flushTimeout {
+		return nil
+	}
+
+	return a.Flush()
+}
+
+// Flush returns the pending record, nil if there is none
+func (a *LineAggregator) Flush() *LogRecord {
+	record := a.pending
+	a.pending = nil
+	return record
+}
+
+func (a *LineAggregator) isContinuation(line string) bool {
+	if matchAny(a.startPatterns, line) {
+		return false
+	} else if matchAny(a.continuationPatterns, line) {
+		return true
+	}
+
+	// without continuation patterns every line but a start line continues the record
+	return len(a.continuationPatterns) == 0
+}
+
+type MultiLineMode int
+
+const (
+	// MultiLineModeJoined mines the whole record as a single message
+	MultiLineModeJoined MultiLineMode = iota
+	// MultiLineModeHeaderOnly mines the header line and ignores the rest of the record
+	MultiLineModeHeaderOnly
+	// MultiLineModeSeparate mines the header line and each continuation line as separate messages
+	MultiLineModeSeparate
+)
+
+type RecordResult struct {
+	Record *LogRecord
+	// result of the header line, or of the whole record in MultiLineModeJoined
+	Header *MiningResult
+	// results of the continuation lines in MultiLineModeSeparate
+	Body []*MiningResult
+}
+
+func (m *TemplateMiner) AddLogRecord(ctx context.Context, record *LogRecord, mode MultiLineMode) (*RecordResult, error) {
+	if len(record.Lines) == 0 {
+		return nil, errors.New("record has no lines")
+	} else if mode < MultiLineModeJoined || mode > MultiLineModeSeparate {
+		return nil, fmt.Errorf("unknown multi line mode %d", mode)
+	}
+
+	recordResult := &RecordResult{Record: record, Body: []*MiningResult{}}
+
+	header := record.Header()
+	if mode == MultiLineModeJoined {
+		// drain splits tokens on spaces only, so lines are joined by a space instead of a newline
+		lines := make([]string, 0, len(record.Lines))
+		for _, line := range record.Lines {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+		header = strings.Join(lines, " ")
+	}
+
+	result, err := m.addLogMessage(ctx, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add header: %w", err)
+	}
+	recordResult.Header = result
+
+	if mode != MultiLineModeSeparate {
+		return recordResult, nil
+	}
+
+	for _, line := range record.Body() {
+		result, err := m.addLogMessage(ctx, line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add continuation line: %w", err)
+		}
+		recordResult.Body = append(recordResult.Body, result)
+	}
+
+	return recordResult, nil
+}
+
+// MultiLineMiner assembles lines into records before mining them
+type MultiLineMiner struct {
+	miner      *TemplateMiner
+	aggregator *LineAggregator
+	mode       MultiLineMode
+}
+
+func NewMultiLineMiner(miner *TemplateMiner, aggregator *LineAggregator, mode MultiLineMode) *MultiLineMiner {
+	return &MultiLineMiner{
+		miner:      miner,
+		aggregator: aggregator,
+		mode:       mode,
+	}
+}
+
+// AddLine returns the results of the records the line completed, usually none or one
+func (m *MultiLineMiner) AddLine(ctx context.Context, line string) ([]*RecordResult, error) {
+	return m.addRecords(ctx, m.aggregator.Add(line))
+}
+
+// FlushExpired mines the pending record if the flush timeout passed, it returns nil otherwise
+func (m *MultiLineMiner) FlushExpired(ctx context.Context) (*RecordResult, error) {
+	return m.addRecord(ctx, m.aggregator.FlushExpired())
+}
+
+// Flush mines the pending record, it returns nil if there is none
+func (m *MultiLineMiner) Flush(ctx context.Context) (*RecordResult, error) {
+	return m.addRecord(ctx, m.aggregator.Flush())
+}
+
+func (m *MultiLineMiner) addRecords(ctx context.Context, records []*LogRecord) ([]*RecordResult, error) {
+	results := []*RecordResult{}
+	for _, record := range records {
+		result, err := m.addRecord(ctx, record)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (m *MultiLineMiner) addRecord(ctx context.Context, record *LogRecord) (*RecordResult, error) {
+	if record == nil {
+		return nil, nil
+	}
+
+	result, err := m.miner.AddLogRecord(ctx, record, m.mode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add log record: %w", err)
+	}
+
+	return result, nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile %q: %w", pattern, err)
+		}
+		compiled = append(compiled, regex)
+	}
+	return compiled, nil
+}
+
+func matchAny(patterns []*regexp.Regexp, line string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
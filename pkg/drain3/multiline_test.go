@@ -0,0 +1,166 @@
+package drain3
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var javaStackTrace = []string{
+	"2024-01-01 00:00:00 ERROR request failed",
+	"java.lang.IllegalStateException: closed",
+	"\tat com.example.Service.handle(Service.java:42)",
+	"\tat com.example.Server.run(Server.java:7)",
+}
+
+func newTestLineAggregator(t *testing.T, options ...aggregatorOptionFn) *LineAggregator {
+	aggregator, err := NewLineAggregator(options...)
+	require.NoError(t, err)
+	return aggregator
+}
+
+func addAggregatorLines(aggregator *LineAggregator, lines ...string) [][]string {
+	records := [][]string{}
+	for _, line := range lines {
+		for _, record := range aggregator.Add(line) {
+			records = append(records, record.Lines)
+		}
+	}
+	return records
+}
+
+func TestLineAggregatorStartPatterns(t *testing.T) {
+	aggregator := newTestLineAggregator(t, WithStartPatterns(`^\d{4}-\d{2}-\d{2} `))
+
+	records := addAggregatorLines(aggregator, append(javaStackTrace, "2024-01-01 00:00:01 INFO done")...)
+	require.Equal(t, [][]string{javaStackTrace}, records)
+	require.Equal(t, []string{"2024-01-01 00:00:01 INFO done"}, aggregator.Flush().Lines)
+	require.Nil(t, aggregator.Flush())
+}
+
+func TestLineAggregatorContinuationPatterns(t *testing.T) {
+	aggregator := newTestLineAggregator(t, WithContinuationPatterns(`^\s+at `, `^\S+Exception: `))
+
+	records := addAggregatorLines(aggregator, "first", javaStackTrace[0], javaStackTrace[1], javaStackTrace[2], "last")
+	require.Equal(t, [][]string{{"first"}, javaStackTrace[:3]}, records)
+
+	// start patterns win over continuation patterns
+	aggregator = newTestLineAggregator(t, WithStartPatterns(`^START`), WithContinuationPatterns(`^\s`))
+	records = addAggregatorLines(aggregator, "START a", " b", "START c")
+	require.Equal(t, [][]string{{"START a", " b"}}, records)
+}
+
+func TestLineAggregatorMaxLines(t *testing.T) {
+	aggregator := newTestLineAggregator(t, WithStartPatterns(`^START`), WithMaxLines(2))
+
+	records := addAggregatorLines(aggregator, "START", "a", "b", "c", "d")
+	require.Equal(t, [][]string{{"START", "a"}, {"b", "c"}}, records)
+	require.Equal(t, []string{"d"}, aggregator.Flush().Lines)
+}
+
+func TestLineAggregatorFlushTimeout(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	aggregator := newTestLineAggregator(t, WithStartPatterns(`^START`), WithFlushTimeout(time.Second), WithAggregatorClock(func() time.Time {
+		return now
+	}))
+
+	require.Empty(t, addAggregatorLines(aggregator, "START", "a"))
+	now = now.Add(999 * time.Millisecond)
+	require.Nil(t, aggregator.FlushExpired())
+
+	now = now.Add(time.Millisecond)
+	require.Equal(t, []string{"START", "a"}, aggregator.FlushExpired().Lines)
+	require.Nil(t, aggregator.FlushExpired())
+
+	// an expired record is emitted before the line which would have continued it
+	require.Empty(t, addAggregatorLines(aggregator, "START"))
+	now = now.Add(time.Second)
+	require.Equal(t, [][]string{{"START"}}, addAggregatorLines(aggregator, "b"))
+	require.Equal(t, []string{"b"}, aggregator.Flush().Lines)
+}
+
+func TestNewLineAggregatorErrors(t *testing.T) {
+	for _, options := range [][]aggregatorOptionFn{
+		{},
+		{WithStartPatterns(`^START`), WithMaxLines(0)},
+		{WithStartPatterns(`(`)},
+		{WithContinuationPatterns(`[`)},
+	} {
+		_, err := NewLineAggregator(options...)
+		require.Error(t, err)
+	}
+}
+
+func TestAddLogRecordModes(t *testing.T) {
+	ctx := context.Background()
+	record := &LogRecord{Lines: javaStackTrace}
+
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence())
+
+	result, err := miner.AddLogRecord(ctx, record, MultiLineModeJoined)
+	require.NoError(t, err)
+	require.Equal(t, "2024-01-01 00:00:00 ERROR request failed java.lang.IllegalStateException: closed "+
+		"at com.example.Service.handle(Service.java:42) at com.example.Server.run(Server.java:7)", result.Header.Template)
+	require.Empty(t, result.Body)
+
+	// the header of another trace is mined apart from its frames
+	result, err = miner.AddLogRecord(ctx, &LogRecord{Lines: []string{"2024-01-01 00:00:05 ERROR request failed", "\tat a.B.c(B.java:1)"}}, MultiLineModeHeaderOnly)
+	require.NoError(t, err)
+	require.Equal(t, ClusterUpdateTypeCreated, result.Header.UpdateType)
+	require.Equal(t, "2024-01-01 00:00:05 ERROR request failed", result.Header.Template)
+	require.Empty(t, result.Body)
+
+	result, err = miner.AddLogRecord(ctx, record, MultiLineModeSeparate)
+	require.NoError(t, err)
+	require.Equal(t, "2024-01-01 <*> ERROR request failed", result.Header.Template)
+	require.Len(t, result.Body, 3)
+	require.Equal(t, "java.lang.IllegalStateException: closed", result.Body[0].Template)
+	require.Equal(t, "at <*>", result.Body[2].Template)
+	require.Equal(t, result.Body[1].Cluster.ClusterId, result.Body[2].Cluster.ClusterId)
+
+	_, err = miner.AddLogRecord(ctx, &LogRecord{Lines: []string{}}, MultiLineModeJoined)
+	require.Error(t, err)
+	_, err = miner.AddLogRecord(ctx, record, MultiLineMode(3))
+	require.Error(t, err)
+}
+
+func TestMultiLineMiner(t *testing.T) {
+	ctx := context.Background()
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	aggregator := newTestLineAggregator(t, WithStartPatterns(`^\d{4}-\d{2}-\d{2} `), WithFlushTimeout(time.Second), WithAggregatorClock(func() time.Time {
+		return now
+	}))
+	miner := NewMultiLineMiner(NewTemplateMiner(drain, NewMemoryPersistence()), aggregator, MultiLineModeHeaderOnly)
+
+	for _, line := range javaStackTrace {
+		results, err := miner.AddLine(ctx, line)
+		require.NoError(t, err)
+		require.Empty(t, results)
+	}
+
+	result, err := miner.FlushExpired(ctx)
+	require.NoError(t, err)
+	require.Nil(t, result)
+
+	now = now.Add(time.Second)
+	result, err = miner.FlushExpired(ctx)
+	require.NoError(t, err)
+	require.Equal(t, javaStackTrace, result.Record.Lines)
+	require.Equal(t, javaStackTrace[0], result.Header.Template)
+
+	results, err := miner.AddLine(ctx, "2024-01-01 00:00:02 ERROR request failed")
+	require.NoError(t, err)
+	require.Empty(t, results)
+	result, err = miner.Flush(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "2024-01-01 <*> ERROR request failed", result.Header.Template)
+
+	result, err = miner.Flush(ctx)
+	require.NoError(t, err)
+	require.Nil(t, result)
+}
@@ -0,0 +1,312 @@
+package drain3
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	hyperLogLogPrecision     = 10
+	hyperLogLogRegisterCount = 1 << hyperLogLogPrecision
+)
+
+// HyperLogLog estimates the number of distinct values added to it with 2^10 one byte registers, about 3% standard error
+type HyperLogLog struct {
+	Registers []byte
+}
+
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{Registers: make([]byte, hyperLogLogRegisterCount)}
+}
+
+// UnmarshalJSON rejects registers of another count, which Add would index out of range
+func (h *HyperLogLog) UnmarshalJSON(data []byte) error {
+	var registers struct {
+		Registers []byte
+	}
+	if err := json.Unmarshal(data, &registers); err != nil {
+		return err
+	} else if len(registers.Registers) != hyperLogLogRegisterCount {
+		return fmt.Errorf("hyperloglog has %d registers instead of %d", len(registers.Registers), hyperLogLogRegisterCount)
+	}
+
+	h.Registers = registers.Registers
+	return nil
+}
+
+func (h *HyperLogLog) Add(value string) {
+	hash := hashValue(value)
+	index := hash >> (64 - hyperLogLogPrecision)
+	// the sentinel bit bounds the rank when every remaining bit is zero
+	rank := byte(bits.LeadingZeros64(hash<<hyperLogLogPrecision|1<<(hyperLogLogPrecision-1)) + 1)
+	if rank > h.Registers[index] {
+		h.Registers[index] = rank
+	}
+}
+
+func (h *HyperLogLog) Estimate() uint64 {
+	registerCount := float64(len(h.Registers))
+	sum, zeroCount := float64(0), 0
+	for _, register := range h.Registers {
+		sum += math.Pow(2, -float64(register))
+		if register == 0 {
+			zeroCount++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/registerCount)
+	estimate := alpha * registerCount * registerCount / sum
+
+	// linear counting is more accurate for small cardinalities
+	if estimate <= 2.5*registerCount && zeroCount > 0 {
+		estimate = registerCount * math.Log(registerCount/float64(zeroCount))
+	}
+
+	return uint64(math.Round(estimate))
+}
+
+// hashValue is a stable 64 bits hash, fnv-1a mixed by the murmur3 finalizer so that every bit is usable by HyperLogLog
+func hashValue(value string) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(value))
+	hash := hasher.Sum64()
+
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+	return hash
+}
+
+type ValueCount struct {
+	Value string
+	Count int64
+	// upper bound of the overestimation of Count
+	Error int64
+}
+
+// SpaceSaving keeps the approximate counts of the most frequent values with a fixed number of counters.
+// a value missing from the counters replaces the least counted one, inheriting its count as error
+type SpaceSaving struct {
+	Capacity int
+	Counters []*ValueCount
+}
+
+func NewSpaceSaving(capacity int) *SpaceSaving {
+	return &SpaceSaving{
+		Capacity: capacity,
+		Counters: []*ValueCount{},
+	}
+}
+
+func (s *SpaceSaving) Add(value string) {
+	var minCounter *ValueCount
+	for _, counter := range s.Counters {
+		if counter.Value == value {
+			counter.Count++
+			return
+		}
+		if minCounter == nil || counter.Count < minCounter.Count {
+			minCounter = counter
+		}
+	}
+
+	if len(s.Counters) < s.Capacity {
+		s.Counters = append(s.Counters, &ValueCount{Value: value, Count: 1})
+		return
+	} else if minCounter == nil {
+		return
+	}
+
+	minCounter.Value = value
+	minCounter.Error = minCounter.Count
+	minCounter.Count++
+}
+
+// Top returns up to k counters, the most frequent first
+func (s *SpaceSaving) Top(k int) []*ValueCount {
+	counters := append([]*ValueCount{}, s.Counters...)
+	sort.SliceStable(counters, func(i, j int) bool {
+		if counters[i].Count != counters[j].Count {
+			return counters[i].Count > counters[j].Count
+		}
+		return counters[i].Value < counters[j].Value
+	})
+
+	if k < len(counters) {
+		counters = counters[:k]
+	}
+	return counters
+}
+
+type NumericStats struct {
+	Count int64
+	Min   float64
+	Max   float64
+	Sum   float64
+}
+
+func (s *NumericStats) Add(value float64) {
+	if s.Count == 0 || value < s.Min {
+		s.Min = value
+	}
+	if s.Count == 0 || value > s.Max {
+		s.Max = value
+	}
+	s.Count++
+	s.Sum += value
+}
+
+func (s *NumericStats) Mean() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// addValueStats updates the statistics of the slot with a value, numeric statistics only count decimal numbers
+func (s *ParamSlot) addValueStats(value string, topK int) {
+	if s.Cardinality == nil {
+		s.Cardinality = NewHyperLogLog()
+	}
+	s.Cardinality.Add(value)
+
+	if s.TopValues == nil {
+		s.TopValues = NewSpaceSaving(topK)
+	}
+	s.TopValues.Add(value)
+
+	if !floatRegex.MatchString(value) {
+		return
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return
+	}
+
+	if s.Numeric == nil {
+		s.Numeric = &NumericStats{}
+	}
+	s.Numeric.Add(number)
+}
+
+// merge adds the statistics of another slot of the same template token
+func (s *ParamSlot) merge(other *ParamSlot) {
+	if s.TypeCounts == nil {
+		s.TypeCounts = map[ParamType]int64{}
+	}
+	for paramType, count := range other.TypeCounts {
+		s.TypeCounts[paramType] += count
+	}
+
+	if other.Cardinality != nil {
+		if s.Cardinality == nil {
+			s.Cardinality = NewHyperLogLog()
+		}
+		s.Cardinality.merge(other.Cardinality)
+	}
+	if other.TopValues != nil {
+		if s.TopValues == nil {
+			s.TopValues = NewSpaceSaving(other.TopValues.Capacity)
+		}
+		s.TopValues.merge(other.TopValues)
+	}
+	if other.Numeric != nil {
+		if s.Numeric == nil {
+			s.Numeric = &NumericStats{}
+		}
+		s.Numeric.merge(other.Numeric)
+	}
+}
+
+// merge keeps the largest rank of every register, which estimates the union of both sets
+func (h *HyperLogLog) merge(other *HyperLogLog) {
+	if len(h.Registers) != len(other.Registers) {
+		return
+	}
+	for i, register := range other.Registers {
+		if register > h.Registers[i] {
+			h.Registers[i] = register
+		}
+	}
+}
+
+// merge sums the counters of both summaries by value and keeps the most counted ones
+func (s *SpaceSaving) merge(other *SpaceSaving) {
+	valueToCounter := map[string]*ValueCount{}
+	for _, counter := range s.Counters {
+		valueToCounter[counter.Value] = counter
+	}
+	for _, counter := range other.Counters {
+		if existing, exist := valueToCounter[counter.Value]; exist {
+			existing.Count += counter.Count
+			existing.Error += counter.Error
+			continue
+		}
+		merged := *counter
+		s.Counters = append(s.Counters, &merged)
+	}
+
+	s.Capacity = max(s.Capacity, other.Capacity)
+	s.Counters = s.Top(s.Capacity)
+}
+
+func (s *NumericStats) merge(other *NumericStats) {
+	if other.Count == 0 {
+		return
+	}
+	if s.Count == 0 || other.Min < s.Min {
+		s.Min = other.Min
+	}
+	if s.Count == 0 || other.Max > s.Max {
+		s.Max = other.Max
+	}
+	s.Count += other.Count
+	s.Sum += other.Sum
+}
+
+// mergeParamSlots merges the slots of a cluster into the slots of another one having a template of the same length
+func mergeParamSlots(target, source map[int]*ParamSlot) map[int]*ParamSlot {
+	if len(source) == 0 {
+		return target
+	} else if target == nil {
+		target = map[int]*ParamSlot{}
+	}
+
+	for tokenIndex, sourceSlot := range source {
+		slot, exist := target[tokenIndex]
+		if !exist {
+			slot = &ParamSlot{TypeCounts: map[ParamType]int64{}}
+			target[tokenIndex] = slot
+		}
+		slot.merge(sourceSlot)
+	}
+	return target
+}
+
+// retainParamSlots drops the slots of a cluster whose token is no longer a parameter after its template changed.
+// slots are keyed by token index, so they are all dropped when the template changed its length
+func (d *Drain) retainParamSlots(cluster *LogCluster, previousLength int) {
+	if len(cluster.LogTemplateTokens) != previousLength {
+		cluster.ParamSlots = nil
+		return
+	}
+
+	for tokenIndex := range cluster.ParamSlots {
+		if tokenIndex >= len(cluster.LogTemplateTokens) {
+			delete(cluster.ParamSlots, tokenIndex)
+		} else if token := cluster.LogTemplateTokens[tokenIndex]; !d.isVariableParam(token) && !strings.Contains(token, d.ParamStr) {
+			delete(cluster.ParamSlots, tokenIndex)
+		}
+	}
+	if len(cluster.ParamSlots) == 0 {
+		cluster.ParamSlots = nil
+	}
+}
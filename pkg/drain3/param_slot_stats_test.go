@@ -0,0 +1,163 @@
+package drain3
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	for _, cardinality := range []int{10, 1000, 100000} {
+		hyperLogLog := NewHyperLogLog()
+		for i := 0; i < cardinality; i++ {
+			// every value is added twice, duplicates must not count
+			hyperLogLog.Add(fmt.Sprintf("value-%d", i))
+			hyperLogLog.Add(fmt.Sprintf("value-%d", i))
+		}
+		require.InEpsilon(t, cardinality, hyperLogLog.Estimate(), 0.1)
+	}
+}
+
+func TestSpaceSaving(t *testing.T) {
+	spaceSaving := NewSpaceSaving(10)
+	for i := 0; i < 100; i++ {
+		spaceSaving.Add("GET")
+		if i%2 == 0 {
+			spaceSaving.Add("POST")
+		}
+		spaceSaving.Add(fmt.Sprintf("rare-%d", i))
+	}
+
+	top := spaceSaving.Top(2)
+	require.Len(t, top, 2)
+	require.Equal(t, "GET", top[0].Value)
+	require.Equal(t, int64(100), top[0].Count)
+	require.Equal(t, "POST", top[1].Value)
+	require.Equal(t, int64(50), top[1].Count)
+	require.Zero(t, top[1].Error)
+}
+
+func TestParamSlotStats(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithParamSlotStats(3))
+
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		_, _, _, _, err := miner.AddLogMessage(ctx, fmt.Sprintf("request from %s took %d ms", []string{"alice", "bob", "alice", "carol"}[i%4], 10+i))
+		require.NoError(t, err)
+	}
+
+	cluster, err := miner.Match("request from dave took 5 ms", SearchStrategyNever)
+	require.NoError(t, err)
+	require.NotNil(t, cluster)
+	require.Equal(t, "request from <*> took <*> ms", cluster.GetTemplate())
+
+	// the first message of the cluster had no parameters yet
+	user := cluster.ParamSlots[2]
+	require.Equal(t, uint64(3), user.Cardinality.Estimate())
+	require.Equal(t, "alice", user.TopValues.Top(1)[0].Value)
+	require.Nil(t, user.Numeric)
+
+	latency := cluster.ParamSlots[4]
+	require.Equal(t, uint64(19), latency.Cardinality.Estimate())
+	require.Equal(t, int64(19), latency.Numeric.Count)
+	require.Equal(t, float64(11), latency.Numeric.Min)
+	require.Equal(t, float64(29), latency.Numeric.Max)
+	require.Equal(t, float64(20), latency.Numeric.Mean())
+
+	for _, codec := range []SnapshotCodec{NewJSONCodec(), NewBinaryCodec()} {
+		state, err := codec.Marshal(drain)
+		require.NoError(t, err)
+
+		loaded, err := codec.Unmarshal(state)
+		require.NoError(t, err)
+
+		loadedCluster, exist := loaded.IdToCluster.Peek(cluster.ClusterId)
+		require.True(t, exist)
+		require.Equal(t, cluster.ParamSlots, loadedCluster.ParamSlots)
+	}
+}
+
+func TestParamSlotsFollowTemplates(t *testing.T) {
+	drain, err := NewDrain(WithSimTh(0.7), WithMaxClusterSamples(10))
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithParamSlotStats(3))
+
+	ctx := context.Background()
+	for _, log := range []string{
+		"GET request from alice took 10 ms ok",
+		"GET request from bob took 11 ms ok",
+		"GET request from alice took 12 ms ok",
+		"GET request to carol took 20 ms ok",
+		"GET request to bob took 21 ms ok",
+	} {
+		_, _, _, _, err := miner.AddLogMessage(ctx, log)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, drain.IdToCluster.Len())
+
+	// slots of templates of the same length are merged by token index
+	cluster, err := drain.MergeClusters(1, 2)
+	require.NoError(t, err)
+	require.Equal(t, "GET request <*> <*> took <*> ms ok", cluster.GetTemplate())
+	require.Len(t, cluster.ParamSlots, 2)
+	require.Equal(t, uint64(2), cluster.ParamSlots[3].Cardinality.Estimate())
+	require.Equal(t, int64(3), cluster.ParamSlots[5].Numeric.Count)
+	require.Equal(t, float64(11), cluster.ParamSlots[5].Numeric.Min)
+	require.Equal(t, float64(21), cluster.ParamSlots[5].Numeric.Max)
+	require.Equal(t, &ValueCount{Value: "bob", Count: 2}, cluster.ParamSlots[3].TopValues.Top(1)[0])
+
+	// the statistics cannot be divided between the clusters of a split
+	clusters, err := drain.SplitCluster(1, 2)
+	require.NoError(t, err)
+	for _, cluster := range clusters {
+		require.Nil(t, cluster.ParamSlots)
+	}
+}
+
+func TestParamSlotsDroppedWhenTemplateShifts(t *testing.T) {
+	drain, err := NewDrain(WithVariableLength(2))
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithParamSlotStats(3))
+
+	ctx := context.Background()
+	for _, log := range []string{"job a b done 5 ms", "job c d done 6 ms", "job done 7 ms"} {
+		_, _, _, _, err := miner.AddLogMessage(ctx, log)
+		require.NoError(t, err)
+	}
+
+	cluster, exist := drain.IdToCluster.Peek(1)
+	require.True(t, exist)
+	require.Equal(t, "job <*...> done <*> ms", cluster.GetTemplate())
+
+	// the slots of the longer template are gone, only the last message is counted at the new token indexes
+	require.Len(t, cluster.ParamSlots, 2)
+	require.Equal(t, int64(1), cluster.ParamSlots[3].Numeric.Count)
+	require.Equal(t, float64(7), cluster.ParamSlots[3].Numeric.Min)
+}
+
+func TestLoadRejectsInvalidHyperLogLog(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithParamSlotStats(3))
+
+	ctx := context.Background()
+	for _, log := range []string{"user alice logged in", "user bob logged in"} {
+		_, _, _, _, err := miner.AddLogMessage(ctx, log)
+		require.NoError(t, err)
+	}
+
+	cluster, exist := drain.IdToCluster.Peek(1)
+	require.True(t, exist)
+	cluster.ParamSlots[1].Cardinality.Registers = cluster.ParamSlots[1].Cardinality.Registers[:3]
+
+	for _, codec := range []SnapshotCodec{NewJSONCodec(), NewBinaryCodec()} {
+		state, err := codec.Marshal(drain)
+		require.NoError(t, err)
+
+		_, err = codec.Unmarshal(state)
+		require.ErrorContains(t, err, "hyperloglog has 3 registers instead of 1024")
+	}
+}
@@ -0,0 +1,284 @@
+package drain3
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ParamType int
+
+const (
+	ParamTypeString ParamType = iota
+	ParamTypeInt
+	ParamTypeFloat
+	ParamTypeHex
+	ParamTypeBool
+	ParamTypeIP
+	ParamTypeUUID
+	ParamTypeDuration
+	ParamTypeTimestamp
+	ParamTypePath
+)
+
+var paramTypeNames = []string{"string", "int", "float", "hex", "bool", "ip", "uuid", "duration", "timestamp", "path"}
+
+func (t ParamType) String() string {
+	if t < 0 || int(t) >= len(paramTypeNames) {
+		return "ParamType(" + strconv.Itoa(int(t)) + ")"
+	}
+	return paramTypeNames[t]
+}
+
+// MarshalText persists types by name, so that snapshots do not depend on the order of the constants
+func (t ParamType) MarshalText() ([]byte, error) {
+	if t < 0 || int(t) >= len(paramTypeNames) {
+		return nil, fmt.Errorf("unknown param type %d", int(t))
+	}
+	return []byte(paramTypeNames[t]), nil
+}
+
+func (t *ParamType) UnmarshalText(text []byte) error {
+	paramType, err := parseParamType(string(text))
+	if err != nil {
+		return err
+	}
+	*t = paramType
+	return nil
+}
+
+// parseParamType returns the type of the given name
+func parseParamType(name string) (ParamType, error) {
+	for i, paramTypeName := range paramTypeNames {
+		if paramTypeName == name {
+			return ParamType(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown param type %q", name)
+}
+
+// inference tries the types in this order, the first one the value parses as wins
+var inferredParamTypes = []ParamType{
+	ParamTypeBool,
+	ParamTypeInt,
+	ParamTypeFloat,
+	ParamTypeHex,
+	ParamTypeUUID,
+	ParamTypeIP,
+	ParamTypeDuration,
+	ParamTypeTimestamp,
+	ParamTypePath,
+}
+
+var (
+	floatRegex = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+	// hex values either have a 0x prefix or are long enough not to be mistaken for words like "cafe"
+	hexRegex  = regexp.MustCompile(`^(0[xX][0-9a-fA-F]+|[0-9a-fA-F]{8,})$`)
+	uuidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	pathRegex = regexp.MustCompile(`^(/|\./|\.\./|~/|[A-Za-z]:\\)\S*$`)
+)
+
+// parameters are single tokens, so layouts containing spaces could never match
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+}
+
+// units a number may be followed by in a template, e.g. "in <*> ms"
+var durationUnits = map[string]time.Duration{
+	"ns":      time.Nanosecond,
+	"us":      time.Microsecond,
+	"µs":      time.Microsecond,
+	"ms":      time.Millisecond,
+	"millis":  time.Millisecond,
+	"s":       time.Second,
+	"sec":     time.Second,
+	"secs":    time.Second,
+	"second":  time.Second,
+	"seconds": time.Second,
+	"min":     time.Minute,
+	"mins":    time.Minute,
+	"minute":  time.Minute,
+	"minutes": time.Minute,
+	"h":       time.Hour,
+	"hour":    time.Hour,
+	"hours":   time.Hour,
+}
+
+// InferParamType returns the type of a parameter value and the value parsed as that type:
+// int64, float64, uint64 for hex, bool, netip.Addr, time.Duration, time.Time, or the string itself for the other types
+func InferParamType(value string) (ParamType, any) {
+	for _, paramType := range inferredParamTypes {
+		if typedValue, ok := parseParamAs(paramType, value); ok {
+			return paramType, typedValue
+		}
+	}
+	return ParamTypeString, value
+}
+
+// inferParamTypeWithUnit infers the type of a value followed by the given template token,
+// numbers followed by a duration unit are durations
+func inferParamTypeWithUnit(value, unit string) (ParamType, any) {
+	if duration, ok := parseDurationWithUnit(value, unit); ok {
+		return ParamTypeDuration, duration
+	}
+	return InferParamType(value)
+}
+
+// inferParamType sets the type of a parameter followed by the given template token when the miner infers types
+func (m *TemplateMiner) inferParamType(parameter *ExtractedParameter, unit string) {
+	if m.inferParamTypes || m.learnParamTypes {
+		parameter.Type, parameter.TypedValue = inferParamTypeWithUnit(parameter.Value, unit)
+	}
+}
+
+func parseDurationWithUnit(value, unit string) (time.Duration, bool) {
+	unitDuration, exist := durationUnits[strings.TrimRight(unit, ".,;:)")]
+	if !exist || !floatRegex.MatchString(value) {
+		return 0, false
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(number * float64(unitDuration)), true
+}
+
+// parseParamAs parses a value as the given type, ok is false when the value is not of that type
+func parseParamAs(paramType ParamType, value string) (any, bool) {
+	switch paramType {
+	case ParamTypeString:
+		return value, true
+	case ParamTypeBool:
+		switch strings.ToLower(value) {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+	case ParamTypeInt:
+		if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return number, true
+		}
+	case ParamTypeFloat:
+		if floatRegex.MatchString(value) {
+			if number, err := strconv.ParseFloat(value, 64); err == nil {
+				return number, true
+			}
+		}
+	case ParamTypeHex:
+		if hexRegex.MatchString(value) {
+			digits := strings.TrimPrefix(strings.TrimPrefix(value, "0x"), "0X")
+			if number, err := strconv.ParseUint(digits, 16, 64); err == nil {
+				return number, true
+			}
+		}
+	case ParamTypeUUID:
+		if uuidRegex.MatchString(value) {
+			return strings.ToLower(value), true
+		}
+	case ParamTypeIP:
+		if addr, err := netip.ParseAddr(value); err == nil {
+			return addr, true
+		}
+	case ParamTypeDuration:
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration, true
+		}
+	case ParamTypeTimestamp:
+		for _, layout := range timestampLayouts {
+			if timestamp, err := time.Parse(layout, value); err == nil {
+				return timestamp, true
+			}
+		}
+	case ParamTypePath:
+		if pathRegex.MatchString(value) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// ParamSlot holds what was learned about the values of a template parameter
+type ParamSlot struct {
+	TypeCounts map[ParamType]int64
+	// value statistics, nil unless the miner collects them (WithParamSlotStats)
+	Cardinality *HyperLogLog
+	TopValues   *SpaceSaving
+	Numeric     *NumericStats
+}
+
+// DominantType returns the most frequent type of the slot, string when nothing was learned
+func (s *ParamSlot) DominantType() ParamType {
+	dominantType, dominantCount := ParamTypeString, int64(0)
+	for paramType, count := range s.TypeCounts {
+		if count > dominantCount || (count == dominantCount && paramType < dominantType) {
+			dominantType, dominantCount = paramType, count
+		}
+	}
+	return dominantType
+}
+
+func (m *TemplateMiner) observeParamSlots(cluster *LogCluster, parameters []*ExtractedParameter) {
+	templateParameters := m.templateParameters(cluster.GetTemplate())
+	if len(parameters) == 0 || len(parameters) != len(templateParameters) {
+		return
+	}
+
+	if cluster.ParamSlots == nil {
+		cluster.ParamSlots = map[int]*ParamSlot{}
+	}
+
+	for i, parameter := range parameters {
+		tokenIndex := templateParameters[i].tokenIndex
+		slot, exist := cluster.ParamSlots[tokenIndex]
+		if !exist {
+			slot = &ParamSlot{TypeCounts: map[ParamType]int64{}}
+			cluster.ParamSlots[tokenIndex] = slot
+		}
+
+		if m.learnParamTypes {
+			slot.TypeCounts[parameter.Type]++
+		}
+		if m.paramSlotStatsTopK > 0 {
+			slot.addValueStats(parameter.Value, m.paramSlotStatsTopK)
+		}
+	}
+}
+
+// ExtractClusterParameters extracts the parameters of a message of the cluster like ExtractParameters,
+// but converts every value which parses as the dominant type learned for its slot to that type
+func (m *TemplateMiner) ExtractClusterParameters(cluster *LogCluster, logMessage string) []*ExtractedParameter {
+	template := cluster.GetTemplate()
+	parameters := m.ExtractParameters(template, logMessage)
+	templateParameters := m.templateParameters(template)
+	if len(parameters) != len(templateParameters) {
+		return parameters
+	}
+
+	for i, parameter := range parameters {
+		slot, exist := cluster.ParamSlots[templateParameters[i].tokenIndex]
+		if !exist {
+			continue
+		}
+
+		dominantType := slot.DominantType()
+		if dominantType == parameter.Type {
+			continue
+		}
+
+		typedValue, ok := parseParamAs(dominantType, parameter.Value)
+		if dominantType == ParamTypeDuration && !ok {
+			typedValue, ok = parseDurationWithUnit(parameter.Value, templateParameters[i].nextToken)
+		}
+		if ok {
+			parameter.Type, parameter.TypedValue = dominantType, typedValue
+		}
+	}
+
+	return parameters
+}
@@ -0,0 +1,128 @@
+package drain3
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestInferParamType(t *testing.T) {
+	testCases := []struct {
+		value        string
+		expectedType ParamType
+		expected     any
+	}{
+		{"42", ParamTypeInt, int64(42)},
+		{"-3.5", ParamTypeFloat, -3.5},
+		{"0xff", ParamTypeHex, uint64(255)},
+		{"deadbeef01", ParamTypeHex, uint64(0xdeadbeef01)},
+		{"True", ParamTypeBool, true},
+		{"10.0.0.1", ParamTypeIP, netip.MustParseAddr("10.0.0.1")},
+		{"::1", ParamTypeIP, netip.MustParseAddr("::1")},
+		{"123E4567-E89B-12D3-A456-426614174000", ParamTypeUUID, "123e4567-e89b-12d3-a456-426614174000"},
+		{"1m30s", ParamTypeDuration, 90 * time.Second},
+		{"2024-03-01T10:00:00Z", ParamTypeTimestamp, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+		{"/var/log/kafka.log", ParamTypePath, "/var/log/kafka.log"},
+		{"cafe", ParamTypeString, "cafe"},
+		{"NaN", ParamTypeString, "NaN"},
+	}
+
+	for _, testCase := range testCases {
+		paramType, typedValue := InferParamType(testCase.value)
+		require.Equal(t, testCase.expectedType, paramType, testCase.value)
+		require.Equal(t, testCase.expected, typedValue, testCase.value)
+	}
+}
+
+func TestParamTypeInference(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+
+	// types are only inferred when asked for
+	parameters := NewTemplateMiner(drain, NewMemoryPersistence()).ExtractParameters("took <*> ms at <*>", "took 3 ms at 2024-03-01T10:00:00Z")
+	require.Equal(t, []*ExtractedParameter{
+		{Value: "3", MaskName: "*"},
+		{Value: "2024-03-01T10:00:00Z", MaskName: "*"},
+	}, parameters)
+
+	parameters = NewTemplateMiner(drain, NewMemoryPersistence(), WithParamTypeInference()).ExtractParameters("took <*> ms at <*>", "took 3 ms at 2024-03-01T10:00:00Z")
+	require.Equal(t, []*ExtractedParameter{
+		{Value: "3", MaskName: "*", Type: ParamTypeDuration, TypedValue: 3 * time.Millisecond},
+		{Value: "2024-03-01T10:00:00Z", MaskName: "*", Type: ParamTypeTimestamp, TypedValue: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+	}, parameters)
+}
+
+func TestParamTypeLearning(t *testing.T) {
+	drain, err := NewDrain(WithExtraDelimiter([]string{"_"}))
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithParamTypeLearning())
+
+	ctx := context.Background()
+	for _, log := range kafkaLogs {
+		_, _, _, _, err := miner.AddLogMessage(ctx, log)
+		require.NoError(t, err)
+	}
+
+	// a number followed by a unit is a duration
+	log := "[Log partition=__consumer_offsets-49, dir=/home1/irteam/apps/data/kafka/kafka-logs] Rolled new log segment at offset 432939698 in 2 ms. (kafka.log.Log)"
+	cluster, err := miner.Match(log, SearchStrategyNever)
+	require.NoError(t, err)
+	require.NotNil(t, cluster)
+
+	parameters := miner.ExtractClusterParameters(cluster, log)
+	require.Len(t, parameters, 3)
+	require.Equal(t, ParamTypeInt, parameters[1].Type)
+	require.Equal(t, int64(432939698), parameters[1].TypedValue)
+	require.Equal(t, ParamTypeDuration, parameters[2].Type)
+	require.Equal(t, 2*time.Millisecond, parameters[2].TypedValue)
+
+	// values are converted to the dominant type of their slot
+	for _, log := range []string{"latency 1.5", "latency 2.5", "latency 0.5", "latency 3"} {
+		_, _, _, _, err := miner.AddLogMessage(ctx, log)
+		require.NoError(t, err)
+	}
+
+	cluster, err = miner.Match("latency 4", SearchStrategyNever)
+	require.NoError(t, err)
+	require.NotNil(t, cluster)
+	require.Equal(t, ParamTypeFloat, cluster.ParamSlots[1].DominantType())
+
+	parameters = miner.ExtractParameters(cluster.GetTemplate(), "latency 4")
+	require.Equal(t, ParamTypeInt, parameters[0].Type)
+	parameters = miner.ExtractClusterParameters(cluster, "latency 4")
+	require.Equal(t, ParamTypeFloat, parameters[0].Type)
+	require.Equal(t, float64(4), parameters[0].TypedValue)
+}
+
+func TestParamSlotTypesPersistedByName(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithParamTypeLearning())
+	for _, log := range []string{"latency 1.5", "latency 2.5"} {
+		_, _, _, _, err := miner.AddLogMessage(context.Background(), log)
+		require.NoError(t, err)
+	}
+
+	state, err := NewJSONCodec().Marshal(drain)
+	require.NoError(t, err)
+	require.Contains(t, string(state), `"TypeCounts":{"float":1}`)
+
+	for _, codec := range []SnapshotCodec{NewJSONCodec(), NewBinaryCodec()} {
+		t.Run(fmt.Sprintf("%T", codec), func(t *testing.T) {
+			state, err := codec.Marshal(drain)
+			require.NoError(t, err)
+			loadedDrain, err := codec.Unmarshal(state)
+			require.NoError(t, err)
+
+			cluster, exist := loadedDrain.IdToCluster.Get(1)
+			require.True(t, exist)
+			require.Equal(t, map[ParamType]int64{ParamTypeFloat: 1}, cluster.ParamSlots[1].TypeCounts)
+		})
+	}
+
+	var paramType ParamType
+	require.Error(t, paramType.UnmarshalText([]byte("5")))
+}
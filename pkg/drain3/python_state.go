@@ -0,0 +1,387 @@
+package drain3
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// jsonpickle tags used by python drain3 TemplateMiner.save_state
+const (
+	pyObjectTag  = "py/object"
+	pyTupleTag   = "py/tuple"
+	pyStateTag   = "py/state"
+	pyIdTag      = "py/id"
+	pyJsonKeyTag = "json://"
+
+	pyDrainClass        = "drain3.drain.Drain"
+	pyNodeClass         = "drain3.drain.Node"
+	pyLogClusterClass   = "drain3.drain.LogCluster"
+	pyNullProfilerClass = "drain3.simple_profiler.NullProfiler"
+)
+
+type pythonDrain struct {
+	PyObject                 string          `json:"py/object"`
+	LogClusterDepth          int64           `json:"log_cluster_depth"`
+	MaxNodeDepth             int64           `json:"max_node_depth"`
+	SimTh                    float64         `json:"sim_th"`
+	MaxChildren              int64           `json:"max_children"`
+	RootNode                 *pythonNode     `json:"root_node"`
+	Profiler                 *pythonObject   `json:"profiler,omitempty"`
+	ExtraDelimiters          pythonSequence  `json:"extra_delimiters"`
+	MaxClusters              *int            `json:"max_clusters"`
+	ParamStr                 string          `json:"param_str"`
+	ParametrizeNumericTokens bool            `json:"parametrize_numeric_tokens"`
+	IdToCluster              json.RawMessage `json:"id_to_cluster"`
+	ClustersCounter          int64           `json:"clusters_counter"`
+}
+
+type pythonNode struct {
+	PyObject       string                 `json:"py/object"`
+	KeyToChildNode map[string]*pythonNode `json:"key_to_child_node"`
+	ClusterIds     []int64                `json:"cluster_ids"`
+}
+
+type pythonLogCluster struct {
+	PyObject          string         `json:"py/object"`
+	LogTemplateTokens pythonSequence `json:"log_template_tokens"`
+	ClusterId         int64          `json:"cluster_id"`
+	Size              int64          `json:"size"`
+}
+
+type pythonObject struct {
+	PyObject string `json:"py/object"`
+}
+
+// pythonSequence is a list of strings which jsonpickle writes either as a plain list or as a tuple
+type pythonSequence []string
+
+func (s *pythonSequence) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*s = list
+		return nil
+	}
+
+	var tagged map[string]json.RawMessage
+	if err := json.Unmarshal(data, &tagged); err != nil {
+		return fmt.Errorf("failed to unmarshal sequence: %w", err)
+	}
+
+	if _, exist := tagged[pyIdTag]; exist {
+		return errors.New("jsonpickle object references are not supported")
+	}
+
+	rawTuple, exist := tagged[pyTupleTag]
+	if !exist {
+		return errors.New("sequence is neither a list nor a tuple")
+	}
+
+	if err := json.Unmarshal(rawTuple, &list); err != nil {
+		return fmt.Errorf("failed to unmarshal tuple: %w", err)
+	}
+
+	*s = list
+	return nil
+}
+
+func (s pythonSequence) MarshalJSON() ([]byte, error) {
+	list := []string(s)
+	if list == nil {
+		list = []string{}
+	}
+	return json.Marshal(map[string][]string{pyTupleTag: list})
+}
+
+// LoadPythonState creates a Drain from a state written by python drain3 TemplateMiner.save_state.
+// both plain and compressed (zlib + base64) states are accepted.
+// python allows an unbounded cluster cache, in that case the default MaxClusters is used, grown to fit every loaded cluster
+func LoadPythonState(state []byte) (*Drain, error) {
+	state, err := decompressPythonState(state)
+	if err != nil {
+		return nil, err
+	}
+
+	var pyDrain pythonDrain
+	if err := json.Unmarshal(state, &pyDrain); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal python state: %w", err)
+	}
+
+	if pyDrain.PyObject != pyDrainClass {
+		return nil, fmt.Errorf("unexpected python object %q, expected %q", pyDrain.PyObject, pyDrainClass)
+	}
+
+	clusters, err := decodePythonClusters(pyDrain.IdToCluster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode python clusters: %w", err)
+	}
+
+	options := []optionFn{
+		WithDepth(pyDrain.LogClusterDepth),
+		WithSimTh(pyDrain.SimTh),
+		WithMaxChildren(pyDrain.MaxChildren),
+		WithExtraDelimiter(append([]string{}, pyDrain.ExtraDelimiters...)),
+	}
+	if pyDrain.MaxClusters != nil {
+		options = append(options, WithMaxCluster(*pyDrain.MaxClusters))
+	}
+
+	drain, err := NewDrain(options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create drain: %w", err)
+	}
+
+	if pyDrain.MaxClusters == nil && len(clusters) > drain.MaxClusters {
+		drain.MaxClusters = len(clusters)
+		drain.IdToCluster.Resize(drain.MaxClusters)
+	}
+
+	drain.ParamStr = pyDrain.ParamStr
+	drain.ParametrizeNumericTokens = pyDrain.ParametrizeNumericTokens
+	drain.ClustersCounter = pyDrain.ClustersCounter
+	if pyDrain.RootNode != nil {
+		drain.RootNode = pyDrain.RootNode.toNode()
+	}
+
+	for _, cluster := range clusters {
+		drain.IdToCluster.Add(cluster.ClusterId, cluster)
+	}
+
+	return drain, nil
+}
+
+// DumpPythonState writes the state of a Drain in the jsonpickle format python drain3 TemplateMiner.load_state reads.
+// compress must match the snapshot_compress_state setting of the python TemplateMiner loading it
+func DumpPythonState(drain *Drain, compress bool) ([]byte, error) {
+	maxClusters := drain.MaxClusters
+	pyDrain := &pythonDrain{
+		PyObject:                 pyDrainClass,
+		LogClusterDepth:          drain.LogClusterDepth,
+		MaxNodeDepth:             drain.MaxNodeDepth,
+		SimTh:                    drain.SimTh,
+		MaxChildren:              drain.MaxChildren,
+		RootNode:                 newPythonNode(drain.RootNode),
+		Profiler:                 &pythonObject{PyObject: pyNullProfilerClass},
+		ExtraDelimiters:          drain.ExtraDelimiters,
+		MaxClusters:              &maxClusters,
+		ParamStr:                 drain.ParamStr,
+		ParametrizeNumericTokens: drain.ParametrizeNumericTokens,
+		ClustersCounter:          drain.ClustersCounter,
+	}
+
+	idToCluster, err := encodePythonClusters(drain.IdToCluster.Values())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode clusters: %w", err)
+	}
+	pyDrain.IdToCluster = idToCluster
+
+	state, err := json.Marshal(pyDrain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal python state: %w", err)
+	}
+
+	if !compress {
+		return state, nil
+	}
+
+	var buf bytes.Buffer
+	writer := zlib.NewWriter(&buf)
+	if _, err := writer.Write(state); err != nil {
+		return nil, fmt.Errorf("failed to compress state: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress state: %w", err)
+	}
+
+	return []byte(base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+func decompressPythonState(state []byte) ([]byte, error) {
+	state = bytes.TrimSpace(state)
+	if len(state) == 0 {
+		return nil, errors.New("python state is empty")
+	}
+
+	// uncompressed jsonpickle state is a json object
+	if state[0] == '{' {
+		return state, nil
+	}
+
+	compressed, err := base64.StdEncoding.DecodeString(string(state))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 state: %w", err)
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zlib reader: %w", err)
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress state: %w", err)
+	}
+
+	return decompressed, nil
+}
+
+func decodePythonClusters(rawIdToCluster json.RawMessage) ([]*LogCluster, error) {
+	if len(rawIdToCluster) == 0 || string(rawIdToCluster) == "null" {
+		return []*LogCluster{}, nil
+	}
+
+	keys, values, err := decodeOrderedObject(rawIdToCluster)
+	if err != nil {
+		return nil, err
+	}
+
+	// a LogClusterCache is pickled as an object wrapping the underlying dict of the cachetools cache
+	for _, key := range keys {
+		if key == pyObjectTag {
+			return decodePythonClusterCache(keys, values)
+		}
+	}
+
+	clusters := []*LogCluster{}
+	for i, key := range keys {
+		clusterId, err := strconv.ParseInt(strings.TrimPrefix(key, pyJsonKeyTag), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse cluster id %q: %w", key, err)
+		}
+
+		// clusters evicted by python are kept as null values
+		if string(values[i]) == "null" {
+			continue
+		}
+
+		var pyCluster pythonLogCluster
+		if err := json.Unmarshal(values[i], &pyCluster); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal cluster %d: %w", clusterId, err)
+		}
+
+		clusters = append(clusters, &LogCluster{
+			ClusterId:         pyCluster.ClusterId,
+			LogTemplateTokens: append([]string{}, pyCluster.LogTemplateTokens...),
+			Size:              pyCluster.Size,
+		})
+	}
+
+	return clusters, nil
+}
+
+func decodePythonClusterCache(keys []string, values []json.RawMessage) ([]*LogCluster, error) {
+	for i, key := range keys {
+		if key == pyStateTag {
+			stateKeys, stateValues, err := decodeOrderedObject(values[i])
+			if err != nil {
+				return nil, err
+			}
+			return decodePythonClusterCache(stateKeys, stateValues)
+		} else if strings.HasSuffix(key, "__data") {
+			return decodePythonClusters(values[i])
+		}
+	}
+
+	return nil, errors.New("cluster cache holds no cluster data")
+}
+
+func encodePythonClusters(clusters []*LogCluster) (json.RawMessage, error) {
+	// python TemplateMiner.load_state converts string keys back to int ids and refills its LRU cache from them,
+	// so clusters are written oldest first to keep the recency order
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, cluster := range clusters {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(strconv.FormatInt(cluster.ClusterId, 10))
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := json.Marshal(&pythonLogCluster{
+			PyObject:          pyLogClusterClass,
+			LogTemplateTokens: cluster.LogTemplateTokens,
+			ClusterId:         cluster.ClusterId,
+			Size:              cluster.Size,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+func (n *pythonNode) toNode() *Node {
+	node := NewNode()
+	for key, child := range n.KeyToChildNode {
+		if child == nil {
+			continue
+		}
+		node.KeyToChildNode[key] = child.toNode()
+	}
+	node.ClusterIds = append(node.ClusterIds, n.ClusterIds...)
+	return node
+}
+
+func newPythonNode(node *Node) *pythonNode {
+	pyNode := &pythonNode{
+		PyObject:       pyNodeClass,
+		KeyToChildNode: map[string]*pythonNode{},
+		ClusterIds:     append([]int64{}, node.ClusterIds...),
+	}
+	for key, child := range node.KeyToChildNode {
+		pyNode.KeyToChildNode[key] = newPythonNode(child)
+	}
+	return pyNode
+}
+
+// decodeOrderedObject decodes a json object keeping the order of its keys
+func decodeOrderedObject(data []byte) ([]string, []json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read object: %w", err)
+	} else if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return nil, nil, fmt.Errorf("expected json object but got %v", token)
+	}
+
+	keys := []string{}
+	values := []json.RawMessage{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read object key: %w", err)
+		}
+
+		key, ok := token.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("expected object key but got %v", token)
+		}
+
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, nil, fmt.Errorf("failed to read value of %q: %w", key, err)
+		}
+
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+
+	return keys, values, nil
+}
@@ -0,0 +1,58 @@
+package drain3
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPythonState(t *testing.T) {
+	state, err := os.ReadFile(filepath.Join("testdata", "python_state.json"))
+	require.NoError(t, err)
+
+	drain, err := LoadPythonState(state)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), drain.ClustersCounter)
+	require.Equal(t, []string{"_"}, drain.ExtraDelimiters)
+	require.Equal(t, 2, drain.IdToCluster.Len())
+
+	cluster, err := drain.Match("user bob logged in", SearchStrategyNever)
+	require.NoError(t, err)
+	require.NotNil(t, cluster)
+	require.Equal(t, int64(2), cluster.ClusterId)
+	require.Equal(t, int64(3), cluster.Size)
+
+	// state written for python must be readable again, compressed or not
+	for _, compress := range []bool{false, true} {
+		dumped, err := DumpPythonState(drain, compress)
+		require.NoError(t, err)
+
+		reloaded, err := LoadPythonState(dumped)
+		require.NoError(t, err)
+		require.Equal(t, drain.ClustersCounter, reloaded.ClustersCounter)
+		require.Equal(t, drain.IdToCluster.Keys(), reloaded.IdToCluster.Keys())
+		require.Equal(t, drain.RootNode, reloaded.RootNode)
+
+		cluster, err := reloaded.Match("connected to 10.0.0.3", SearchStrategyNever)
+		require.NoError(t, err)
+		require.NotNil(t, cluster)
+		require.Equal(t, int64(1), cluster.ClusterId)
+	}
+}
+
+func TestLoadPythonStateWithClusterCache(t *testing.T) {
+	state := `{"py/object": "drain3.drain.Drain", "log_cluster_depth": 4, "max_node_depth": 2, "sim_th": 0.4, "max_children": 100,
+		"root_node": {"py/object": "drain3.drain.Node", "key_to_child_node": {}, "cluster_ids": []},
+		"extra_delimiters": {"py/tuple": []}, "max_clusters": 10, "param_str": "<*>", "parametrize_numeric_tokens": true,
+		"id_to_cluster": {"py/object": "drain3.drain.LogClusterCache", "py/state": {"_Cache__data": {"json://7": {"py/object": "drain3.drain.LogCluster", "log_template_tokens": {"py/tuple": ["disk", "full"]}, "cluster_id": 7, "size": 1}}, "_Cache__maxsize": 10}},
+		"clusters_counter": 7}`
+
+	drain, err := LoadPythonState([]byte(state))
+	require.NoError(t, err)
+	require.Equal(t, 10, drain.MaxClusters)
+
+	cluster, exist := drain.IdToCluster.Peek(7)
+	require.True(t, exist)
+	require.Equal(t, "disk full", cluster.GetTemplate())
+}
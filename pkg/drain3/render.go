@@ -0,0 +1,146 @@
+package drain3
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MessageLayout holds what tokenisation drops from a message: the whitespace around it
+// and the separator between each pair of tokens, a single space or one of the extra delimiters
+type MessageLayout struct {
+	Leading    string
+	Trailing   string
+	Separators []string
+}
+
+// getMessageLayout returns the layout of a message, nil when its separators cannot be told apart,
+// e.g. when an extra delimiter starts with another one
+func (d *Drain) getMessageLayout(content string) *MessageLayout {
+	leading, trimmed, trailing := splitOuterSpaces(content)
+	tokens := d.getContentAsTokens(content)
+	separatorCandidates := append([]string{" "}, d.ExtraDelimiters...)
+
+	layout := &MessageLayout{
+		Leading:    leading,
+		Trailing:   trailing,
+		Separators: make([]string, 0, len(tokens)-1),
+	}
+
+	rest := trimmed
+	for i, token := range tokens {
+		if !strings.HasPrefix(rest, token) {
+			return nil
+		}
+		rest = rest[len(token):]
+
+		if i == len(tokens)-1 {
+			break
+		}
+
+		separator := ""
+		for _, candidate := range separatorCandidates {
+			if candidate != "" && strings.HasPrefix(rest, candidate) && strings.HasPrefix(rest[len(candidate):], tokens[i+1]) {
+				separator = candidate
+				break
+			}
+		}
+		if separator == "" {
+			return nil
+		}
+		layout.Separators = append(layout.Separators, separator)
+		rest = rest[len(separator):]
+	}
+
+	if rest != "" {
+		return nil
+	}
+
+	return layout
+}
+
+// GetMessageLayout returns the layout of a message, which Render needs to reproduce it exactly.
+// nil is returned when the separators of the message are ambiguous
+func (m *TemplateMiner) GetMessageLayout(content string) *MessageLayout {
+	return m.drain.getMessageLayout(content)
+}
+
+// Render is the inverse of ExtractParameters: it replaces the parameters of the template of a cluster by the values in order.
+// tokens are joined by single spaces without a layout, with the layout of the original message its exact line is returned
+func (m *TemplateMiner) Render(cluster *LogCluster, params []string, layout *MessageLayout) (string, error) {
+	variableParamStr := ""
+	if m.drain.VariableLength {
+		variableParamStr = m.drain.VariableParamStr
+	}
+	return renderTemplate(cluster.LogTemplateTokens, params, layout, m.drain.ParamStr, variableParamStr)
+}
+
+// renderTemplate renders the tokens of a template like Render, variableParamStr is empty when templates have no variable parameter
+func renderTemplate(templateTokens []string, params []string, layout *MessageLayout, paramStr, variableParamStr string) (string, error) {
+	tokens := []string{}
+	paramIndex := 0
+
+	nextParam := func() (string, error) {
+		if paramIndex >= len(params) {
+			return "", fmt.Errorf("template %q has more than %d parameters", strings.Join(templateTokens, " "), len(params))
+		}
+		paramIndex++
+		return params[paramIndex-1], nil
+	}
+
+	for _, templateToken := range templateTokens {
+		// a variable parameter stands for any number of tokens, none when its value is empty
+		if variableParamStr != "" && templateToken == variableParamStr {
+			value, err := nextParam()
+			if err != nil {
+				return "", err
+			}
+			if value != "" {
+				tokens = append(tokens, strings.Split(value, " ")...)
+			}
+			continue
+		}
+
+		token := strings.Builder{}
+		rest := templateToken
+		for {
+			index := strings.Index(rest, paramStr)
+			if index < 0 {
+				break
+			}
+
+			value, err := nextParam()
+			if err != nil {
+				return "", err
+			}
+			token.WriteString(rest[:index])
+			token.WriteString(value)
+			rest = rest[index+len(paramStr):]
+		}
+		token.WriteString(rest)
+		tokens = append(tokens, token.String())
+	}
+
+	if paramIndex != len(params) {
+		return "", fmt.Errorf("template %q has %d parameters, got %d", strings.Join(templateTokens, " "), paramIndex, len(params))
+	}
+
+	if layout == nil {
+		return strings.Join(tokens, " "), nil
+	} else if len(tokens) == 0 {
+		return layout.Leading + layout.Trailing, nil
+	} else if len(layout.Separators) != len(tokens)-1 {
+		return "", fmt.Errorf("layout has %d separators for %d tokens", len(layout.Separators), len(tokens))
+	}
+
+	rendered := strings.Builder{}
+	rendered.WriteString(layout.Leading)
+	for i, token := range tokens {
+		if i > 0 {
+			rendered.WriteString(layout.Separators[i-1])
+		}
+		rendered.WriteString(token)
+	}
+	rendered.WriteString(layout.Trailing)
+
+	return rendered.String(), nil
+}
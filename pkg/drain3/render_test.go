@@ -0,0 +1,188 @@
+package drain3
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	drain, err := NewDrain(WithExtraDelimiter([]string{","}))
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithMessageLayouts())
+
+	_, err = miner.AddLogLine(context.Background(), "user 1 logged in")
+	require.NoError(t, err)
+	result, err := miner.AddLogLine(context.Background(), "  user 2,logged in\t")
+	require.NoError(t, err)
+	require.Equal(t, "user <*> logged in", result.Template)
+	require.Equal(t, &MessageLayout{Leading: "  ", Trailing: "\t", Separators: []string{" ", ",", " "}}, result.Layout)
+
+	rendered, err := miner.Render(result.Cluster, []string{"3"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "user 3 logged in", rendered)
+
+	rendered, err = miner.Render(result.Cluster, []string{"3"}, result.Layout)
+	require.NoError(t, err)
+	require.Equal(t, "  user 3,logged in\t", rendered)
+
+	_, err = miner.Render(result.Cluster, []string{}, nil)
+	require.Error(t, err)
+	_, err = miner.Render(result.Cluster, []string{"3", "4"}, nil)
+	require.Error(t, err)
+	_, err = miner.Render(result.Cluster, []string{"3"}, &MessageLayout{Separators: []string{" "}})
+	require.Error(t, err)
+}
+
+func TestRenderAmbiguousLayout(t *testing.T) {
+	t.Run("delimiters made of spaces", func(t *testing.T) {
+		drain, err := NewDrain(WithExtraDelimiter([]string{" ", "  "}))
+		require.NoError(t, err)
+		miner := NewTemplateMiner(drain, NewMemoryPersistence())
+
+		require.NotNil(t, miner.GetMessageLayout("a b"))
+	})
+
+	t.Run("delimiters created by replacements", func(t *testing.T) {
+		drain, err := NewDrain(WithExtraDelimiter([]string{"b", "a c"}))
+		require.NoError(t, err)
+		miner := NewTemplateMiner(drain, NewMemoryPersistence())
+
+		// replacing "b" creates an occurrence of "a c", so the separators are not in the original message
+		require.Nil(t, miner.GetMessageLayout("xabc"))
+	})
+}
+
+// generateLines returns lines of a few templates with random values, surrounding whitespace and the given separators.
+// runs of spaces are left out, as they are tokenised into empty tokens which the extraction regex does not accept as parameters
+func generateLines(random *rand.Rand, count int, separators []string) []string {
+	templates := [][]string{
+		{"connection", "from", "%s", "port", "%s", "closed"},
+		{"user", "%s", "logged", "in"},
+		{"request", "%s", "took", "%s", "ms"},
+		{"job", "%s", "failed", "with", "%s", "%s"},
+	}
+	words := []string{"alpha", "beta", "10.0.0.1", "42", "0x1f", "a=b", "/var/log"}
+	spaces := []string{"", " ", "\t", "  "}
+
+	lines := []string{}
+	for i := 0; i < count; i++ {
+		template := templates[random.Intn(len(templates))]
+		line := strings.Builder{}
+		line.WriteString(spaces[random.Intn(len(spaces))])
+		for j, token := range template {
+			if j > 0 {
+				line.WriteString(separators[random.Intn(len(separators))])
+			}
+			if token == "%s" {
+				token = fmt.Sprintf("%s%d", words[random.Intn(len(words))], random.Intn(100))
+			}
+			line.WriteString(token)
+		}
+		line.WriteString(spaces[random.Intn(len(spaces))])
+		lines = append(lines, line.String())
+	}
+	return lines
+}
+
+// tokenSeparators separates tokens by a single space or one of the delimiters
+func tokenSeparators(delimiters []string) []string {
+	return append([]string{" "}, delimiters...)
+}
+
+// mixedSeparators also mixes tabs into the separators, which the layout of a message keeps
+func mixedSeparators(delimiters []string) []string {
+	separators := []string{" ", "\t ", " \t"}
+	for _, delimiter := range delimiters {
+		separators = append(separators, delimiter, delimiter+"\t", "\t"+delimiter)
+	}
+	return separators
+}
+
+func TestRenderRoundTrip(t *testing.T) {
+	newDrains := map[string]func() (*Drain, error){
+		"default": func() (*Drain, error) {
+			return NewDrain()
+		},
+		"extra delimiters": func() (*Drain, error) {
+			return NewDrain(WithExtraDelimiter([]string{",", ";"}))
+		},
+		"variable length": func() (*Drain, error) {
+			return NewDrain(WithVariableLength(3))
+		},
+		"variable length with extra delimiters": func() (*Drain, error) {
+			return NewDrain(WithVariableLength(3), WithExtraDelimiter([]string{",", ";"}))
+		},
+	}
+
+	for name, newDrain := range newDrains {
+		t.Run(name, func(t *testing.T) {
+			drain, err := newDrain()
+			require.NoError(t, err)
+			miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithMessageLayouts())
+
+			random := rand.New(rand.NewSource(1))
+			lines := append(generateLines(random, 500, mixedSeparators(drain.ExtraDelimiters)), kafkaLogs...)
+
+			layouts := []*MessageLayout{}
+			for _, line := range lines {
+				result, err := miner.AddLogLine(context.Background(), line)
+				require.NoError(t, err)
+				require.NotNil(t, result.Layout, line)
+				layouts = append(layouts, result.Layout)
+			}
+
+			// every line is rendered from the final template of its cluster
+			for i, line := range lines {
+				cluster, err := miner.Match(line, SearchStrategyAlways)
+				require.NoError(t, err)
+				require.NotNil(t, cluster, line)
+
+				parameters := miner.ExtractParameters(cluster.GetTemplate(), strings.TrimSpace(line))
+				require.NotNil(t, parameters, line)
+				values := []string{}
+				for _, parameter := range parameters {
+					values = append(values, parameter.Value)
+				}
+
+				rendered, err := miner.Render(cluster, values, layouts[i])
+				require.NoError(t, err)
+				require.Equal(t, line, rendered)
+			}
+		})
+	}
+}
+
+func TestRenderVariableParamSeparators(t *testing.T) {
+	drain, err := NewDrain(WithVariableLength(3), WithExtraDelimiter([]string{",", ";"}))
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithMessageLayouts())
+
+	// the values of the variable parameter span delimiters and tabs, which its value does not keep
+	lines := []string{
+		"retry alpha done",
+		"retry alpha,beta done",
+		"retry alpha; beta\tgamma done",
+		"retry alpha \tbeta,\tgamma delta done",
+	}
+	results := []*LineResult{}
+	for _, line := range lines {
+		result, err := miner.AddLogLine(context.Background(), line)
+		require.NoError(t, err)
+		results = append(results, result)
+	}
+
+	cluster := results[len(results)-1].Cluster
+	require.Equal(t, "retry alpha <*...> done", cluster.GetTemplate())
+	for i, line := range lines {
+		parameters := miner.ExtractParameters(cluster.GetTemplate(), line)
+		require.Len(t, parameters, 1, line)
+
+		rendered, err := miner.Render(cluster, []string{parameters[0].Value}, results[i].Layout)
+		require.NoError(t, err)
+		require.Equal(t, line, rendered)
+	}
+}
@@ -0,0 +1,205 @@
+package drain3
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type SampleReason int
+
+const (
+	// SampleReasonNew keeps a line which created or changed the template of its cluster
+	SampleReasonNew SampleReason = iota
+	// SampleReasonRare keeps a line of a cluster not larger than the rare size
+	SampleReasonRare
+	// SampleReasonSampled keeps a line which passed the sampling and rate limit of its cluster
+	SampleReasonSampled
+	// SampleReasonSampledOut drops a line after the first N lines of its cluster which is not the M-th one
+	SampleReasonSampledOut
+	// SampleReasonRateLimited drops a line above the rate limit of its cluster
+	SampleReasonRateLimited
+)
+
+func (r SampleReason) String() string {
+	switch r {
+	case SampleReasonNew:
+		return "new"
+	case SampleReasonRare:
+		return "rare"
+	case SampleReasonSampled:
+		return "sampled"
+	case SampleReasonSampledOut:
+		return "sampled_out"
+	case SampleReasonRateLimited:
+		return "rate_limited"
+	default:
+		return "unknown"
+	}
+}
+
+// SampleCounts counts the lines of a cluster the sampler kept and dropped
+type SampleCounts struct {
+	Kept        int64
+	SampledOut  int64
+	RateLimited int64
+}
+
+func (c SampleCounts) Dropped() int64 {
+	return c.SampledOut + c.RateLimited
+}
+
+type SampleResult struct {
+	*LineResult
+	Keep   bool
+	Reason SampleReason
+}
+
+// Sampler decides whether to keep each line from the cluster TemplateMiner mines it into,
+// so that a few noisy templates do not drown the others
+type Sampler struct {
+	miner *TemplateMiner
+
+	rareSize       int64
+	firstN         int64
+	oneInM         int64
+	ratePerSecond  float64
+	rateBurst      float64
+	clusterSamples map[int64]*clusterSample
+
+	now func() time.Time
+}
+
+type clusterSample struct {
+	// lines of the cluster which were neither new nor rare
+	seen       int64
+	tokens     float64
+	lastRefill time.Time
+	counts     SampleCounts
+}
+
+type samplerOptionFn func(*Sampler)
+
+// WithRareTemplateSize always keeps the lines of clusters holding at most size messages
+func WithRareTemplateSize(size int64) samplerOptionFn {
+	return func(sampler *Sampler) {
+		sampler.rareSize = size
+	}
+}
+
+// WithFirstNThenOneInM keeps the first n lines of every cluster, then one line in m
+func WithFirstNThenOneInM(n, m int64) samplerOptionFn {
+	return func(sampler *Sampler) {
+		sampler.firstN = n
+		sampler.oneInM = m
+	}
+}
+
+// WithTemplateRateLimit keeps at most ratePerSecond lines per second of every cluster, with bursts of up to burst lines
+func WithTemplateRateLimit(ratePerSecond float64, burst int) samplerOptionFn {
+	return func(sampler *Sampler) {
+		sampler.ratePerSecond = ratePerSecond
+		sampler.rateBurst = float64(burst)
+	}
+}
+
+// WithSamplerClock replaces time.Now as the clock the rate limit refills by
+func WithSamplerClock(now func() time.Time) samplerOptionFn {
+	return func(sampler *Sampler) {
+		sampler.now = now
+	}
+}
+
+func NewSampler(miner *TemplateMiner, options ...samplerOptionFn) (*Sampler, error) {
+	sampler := &Sampler{
+		miner:          miner,
+		oneInM:         1,
+		clusterSamples: map[int64]*clusterSample{},
+		now:            time.Now,
+	}
+
+	for _, option := range options {
+		option(sampler)
+	}
+
+	if sampler.firstN < 0 || sampler.oneInM < 1 {
+		return nil, errors.New("first n must not be negative and m must be at least 1")
+	} else if sampler.ratePerSecond < 0 || (sampler.ratePerSecond > 0 && sampler.rateBurst < 1) {
+		return nil, errors.New("rate limit must not be negative and its burst must be at least 1")
+	}
+
+	return sampler, nil
+}
+
+// Sample mines a line with AddLogLine and decides whether to keep it.
+// new and rare templates are always kept, other lines have to pass both the first N then 1 in M sampling and the rate limit
+func (s *Sampler) Sample(ctx context.Context, line string) (*SampleResult, error) {
+	lineResult, err := s.miner.AddLogLine(ctx, line)
+	if err != nil {
+		return nil, err
+	}
+
+	sample := s.getClusterSample(lineResult.Cluster.ClusterId)
+	result := &SampleResult{LineResult: lineResult, Keep: true}
+
+	switch {
+	case lineResult.UpdateType != ClusterUpdateTypeNone:
+		result.Reason = SampleReasonNew
+	case lineResult.Cluster.Size <= s.rareSize:
+		result.Reason = SampleReasonRare
+	default:
+		result.Reason = s.sample(sample)
+		result.Keep = result.Reason == SampleReasonSampled
+	}
+
+	switch result.Reason {
+	case SampleReasonSampledOut:
+		sample.counts.SampledOut++
+	case SampleReasonRateLimited:
+		sample.counts.RateLimited++
+	default:
+		sample.counts.Kept++
+	}
+
+	return result, nil
+}
+
+func (s *Sampler) sample(sample *clusterSample) SampleReason {
+	sample.seen++
+	if sample.seen > s.firstN && (sample.seen-s.firstN)%s.oneInM != 0 {
+		return SampleReasonSampledOut
+	}
+
+	if s.ratePerSecond > 0 {
+		now := s.now()
+		sample.tokens = min(s.rateBurst, sample.tokens+now.Sub(sample.lastRefill).Seconds()*s.ratePerSecond)
+		sample.lastRefill = now
+		if sample.tokens < 1 {
+			return SampleReasonRateLimited
+		}
+		sample.tokens--
+	}
+
+	return SampleReasonSampled
+}
+
+func (s *Sampler) getClusterSample(clusterId int64) *clusterSample {
+	if sample, exist := s.clusterSamples[clusterId]; exist {
+		return sample
+	}
+
+	forgetEvictedClusters(s.miner.drain, s.clusterSamples)
+	sample := &clusterSample{tokens: s.rateBurst, lastRefill: s.now()}
+	s.clusterSamples[clusterId] = sample
+	return sample
+}
+
+// Counts returns the lines kept and dropped so far per cluster id.
+// the counts of a cluster evicted from the drain may be forgotten along with it once it is no longer sampled
+func (s *Sampler) Counts() map[int64]SampleCounts {
+	counts := make(map[int64]SampleCounts, len(s.clusterSamples))
+	for clusterId, sample := range s.clusterSamples {
+		counts[clusterId] = sample.counts
+	}
+	return counts
+}
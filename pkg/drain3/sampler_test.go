@@ -0,0 +1,115 @@
+package drain3
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSampler(t *testing.T, options ...samplerOptionFn) *Sampler {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	sampler, err := NewSampler(NewTemplateMiner(drain, NewMemoryPersistence()), options...)
+	require.NoError(t, err)
+	return sampler
+}
+
+func sampleReasons(t *testing.T, sampler *Sampler, lines []string) []SampleReason {
+	reasons := []SampleReason{}
+	for _, line := range lines {
+		result, err := sampler.Sample(context.Background(), line)
+		require.NoError(t, err)
+		require.Equal(t, result.Reason == SampleReasonNew || result.Reason == SampleReasonRare || result.Reason == SampleReasonSampled, result.Keep)
+		reasons = append(reasons, result.Reason)
+	}
+	return reasons
+}
+
+func TestSamplerFirstNThenOneInM(t *testing.T) {
+	sampler := newTestSampler(t, WithFirstNThenOneInM(2, 3))
+
+	lines := []string{}
+	for i := 0; i < 10; i++ {
+		lines = append(lines, fmt.Sprintf("request %d done", i))
+	}
+	lines = append(lines, "disk full")
+
+	// the first two lines create and change the template
+	require.Equal(t, []SampleReason{
+		SampleReasonNew, SampleReasonNew,
+		SampleReasonSampled, SampleReasonSampled,
+		SampleReasonSampledOut, SampleReasonSampledOut, SampleReasonSampled,
+		SampleReasonSampledOut, SampleReasonSampledOut, SampleReasonSampled,
+		SampleReasonNew,
+	}, sampleReasons(t, sampler, lines))
+
+	require.Equal(t, map[int64]SampleCounts{
+		1: {Kept: 6, SampledOut: 4},
+		2: {Kept: 1},
+	}, sampler.Counts())
+	require.Equal(t, int64(4), sampler.Counts()[1].Dropped())
+}
+
+func TestSamplerRareTemplates(t *testing.T) {
+	sampler := newTestSampler(t, WithRareTemplateSize(3), WithFirstNThenOneInM(0, 100))
+
+	lines := []string{}
+	for i := 0; i < 5; i++ {
+		lines = append(lines, fmt.Sprintf("user %d logged in", i))
+	}
+	require.Equal(t, []SampleReason{
+		SampleReasonNew, SampleReasonNew, SampleReasonRare, SampleReasonSampledOut, SampleReasonSampledOut,
+	}, sampleReasons(t, sampler, lines))
+}
+
+func TestSamplerRateLimit(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sampler := newTestSampler(t, WithTemplateRateLimit(2, 2), WithSamplerClock(func() time.Time {
+		return now
+	}))
+
+	lines := []string{"a 1", "a 2", "a 3", "a 4", "a 5"}
+	require.Equal(t, []SampleReason{
+		SampleReasonNew, SampleReasonNew, SampleReasonSampled, SampleReasonSampled, SampleReasonRateLimited,
+	}, sampleReasons(t, sampler, lines))
+
+	// half a second refills a single token
+	now = now.Add(500 * time.Millisecond)
+	require.Equal(t, []SampleReason{SampleReasonSampled, SampleReasonRateLimited}, sampleReasons(t, sampler, []string{"a 6", "a 7"}))
+
+	// tokens never exceed the burst
+	now = now.Add(time.Hour)
+	require.Equal(t, []SampleReason{
+		SampleReasonSampled, SampleReasonSampled, SampleReasonRateLimited,
+	}, sampleReasons(t, sampler, []string{"a 8", "a 9", "a 10"}))
+
+	require.Equal(t, SampleCounts{Kept: 7, RateLimited: 3}, sampler.Counts()[1])
+}
+
+func TestSamplerForgetsEvictedClusters(t *testing.T) {
+	drain, err := NewDrain(WithMaxCluster(2))
+	require.NoError(t, err)
+	sampler, err := NewSampler(NewTemplateMiner(drain, NewMemoryPersistence()))
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		_, err := sampler.Sample(context.Background(), strings.Repeat("word ", i+1))
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(sampler.Counts()), 4)
+	}
+	require.Contains(t, sampler.Counts(), int64(10))
+}
+
+func TestNewSamplerErrors(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence())
+
+	for _, option := range []samplerOptionFn{WithFirstNThenOneInM(-1, 1), WithFirstNThenOneInM(1, 0), WithTemplateRateLimit(-1, 1), WithTemplateRateLimit(1, 0)} {
+		_, err := NewSampler(miner, option)
+		require.Error(t, err)
+	}
+}
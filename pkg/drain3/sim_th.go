@@ -0,0 +1,187 @@
+package drain3
+
+import "strconv"
+
+// SimThStrategy decides the similarity a message needs to join a cluster instead of the global SimTh
+type SimThStrategy interface {
+	// Threshold returns the threshold for a message of the given token count routed to the prefix tree leaf.
+	// leaves are identified by the keys leading to them, which stay the same when the tree is rebuilt or loaded.
+	// the leaf is empty when the message has no leaf yet
+	Threshold(leaf string, tokenCount int) float64
+	// Observe receives the similarity of every message to the most similar cluster of its leaf, before it is added
+	Observe(leaf string, tokenCount int, sim float64)
+}
+
+// LearningSimThStrategy is a SimThStrategy learning statistics per leaf from the similarities it observes.
+// drain saves them in snapshots, and forgets those of the leaves left without clusters once they outnumber twice MaxClusters
+type LearningSimThStrategy interface {
+	SimThStrategy
+	LeafStats() map[string]*LeafSimStats
+	// SetLeafStats replaces the learned statistics by the ones of a snapshot, nil when it has none
+	SetLeafStats(leafStats map[string]*LeafSimStats)
+}
+
+// LeafSimStats sums the similarities observed in a leaf
+type LeafSimStats struct {
+	Count int64
+	Sum   float64
+}
+
+// leafMatch returns the cluster of the leaf of the tokens they are similar enough to join, and the threshold they needed.
+// the best match of the leaf is computed once, both for the strategy to observe its similarity and to compare it to the threshold
+func (d *Drain) leafMatch(partition string, tokens []string) (*LogCluster, float64, error) {
+	leaf, leafPath := d.searchLeafPath(d.RootNode, partition, tokens, d.SimThStrategy != nil)
+
+	simTh := d.SimTh
+	if leaf == nil || len(tokens) == 0 {
+		if d.SimThStrategy != nil {
+			simTh = d.SimThStrategy.Threshold(leafPath, len(tokens))
+		}
+		cluster, err := d.leafSearch(leaf, tokens, simTh, false)
+		return cluster, simTh, err
+	}
+
+	cluster, sim, err := d.bestMatch(leaf.ClusterIds, tokens, false)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// the message is judged by the threshold learned before it, not by one it contributed to
+	if d.SimThStrategy != nil {
+		simTh = d.SimThStrategy.Threshold(leafPath, len(tokens))
+		if cluster != nil {
+			d.SimThStrategy.Observe(leafPath, len(tokens), sim)
+			d.forgetDeadLeaves()
+		}
+	}
+
+	if sim < simTh {
+		return nil, simTh, nil
+	}
+	return cluster, simTh, nil
+}
+
+// TokenCountSimTh interpolates the threshold linearly between the one of short messages and the one of long messages,
+// as short messages differ by a larger share of their tokens when a single token changes
+type TokenCountSimTh struct {
+	shortTokens int
+	shortSimTh  float64
+	longTokens  int
+	longSimTh   float64
+}
+
+func NewTokenCountSimTh(shortTokens int, shortSimTh float64, longTokens int, longSimTh float64) *TokenCountSimTh {
+	return &TokenCountSimTh{
+		shortTokens: shortTokens,
+		shortSimTh:  shortSimTh,
+		longTokens:  longTokens,
+		longSimTh:   longSimTh,
+	}
+}
+
+func (s *TokenCountSimTh) Threshold(_ string, tokenCount int) float64 {
+	if tokenCount <= s.shortTokens || s.longTokens <= s.shortTokens {
+		return s.shortSimTh
+	} else if tokenCount >= s.longTokens {
+		return s.longSimTh
+	}
+
+	ratio := float64(tokenCount-s.shortTokens) / float64(s.longTokens-s.shortTokens)
+	return s.shortSimTh + ratio*(s.longSimTh-s.shortSimTh)
+}
+
+func (s *TokenCountSimTh) Observe(_ string, _ int, _ float64) {}
+
+// LeafAdaptiveSimTh learns the threshold of each prefix tree leaf from the similarities observed in it.
+// leaves whose messages are usually very similar to their clusters get a higher threshold, so that a differing message
+// starts a new cluster, while leaves of more variable messages get a lower one. learned thresholds are saved in snapshots
+// by leaf path, so that they still apply once the state is loaded again
+type LeafAdaptiveSimTh struct {
+	minSimTh float64
+	maxSimTh float64
+	ratio    float64
+
+	leafStats map[string]*LeafSimStats
+}
+
+// NewLeafAdaptiveSimTh returns a strategy using ratio times the mean observed similarity of a leaf, bounded by minSimTh and maxSimTh.
+// leaves without observation use minSimTh
+func NewLeafAdaptiveSimTh(minSimTh, maxSimTh, ratio float64) *LeafAdaptiveSimTh {
+	return &LeafAdaptiveSimTh{
+		minSimTh:  minSimTh,
+		maxSimTh:  maxSimTh,
+		ratio:     ratio,
+		leafStats: map[string]*LeafSimStats{},
+	}
+}
+
+func (s *LeafAdaptiveSimTh) Threshold(leaf string, _ int) float64 {
+	stats, exist := s.leafStats[leaf]
+	if !exist || stats.Count == 0 {
+		return s.minSimTh
+	}
+
+	return min(max(s.ratio*stats.Sum/float64(stats.Count), s.minSimTh), s.maxSimTh)
+}
+
+func (s *LeafAdaptiveSimTh) Observe(leaf string, _ int, sim float64) {
+	stats, exist := s.leafStats[leaf]
+	if !exist {
+		stats = &LeafSimStats{}
+		s.leafStats[leaf] = stats
+	}
+
+	stats.Count++
+	stats.Sum += sim
+}
+
+func (s *LeafAdaptiveSimTh) LeafStats() map[string]*LeafSimStats {
+	return s.leafStats
+}
+
+func (s *LeafAdaptiveSimTh) SetLeafStats(leafStats map[string]*LeafSimStats) {
+	if leafStats == nil {
+		leafStats = map[string]*LeafSimStats{}
+	}
+	s.leafStats = leafStats
+}
+
+// learnedLeafStats returns the statistics of a learning strategy, or the ones loaded from a snapshot while no strategy is set
+func (d *Drain) learnedLeafStats() map[string]*LeafSimStats {
+	if strategy, ok := d.SimThStrategy.(LearningSimThStrategy); ok {
+		return strategy.LeafStats()
+	}
+	return d.leafSimStats
+}
+
+// forgetDeadLeaves deletes the statistics of the leaves without a cluster left in the cache,
+// once there are twice as many leaves as the drain can hold clusters
+func (d *Drain) forgetDeadLeaves() {
+	leafStats := d.learnedLeafStats()
+	if len(leafStats) < 2*d.MaxClusters {
+		return
+	}
+
+	liveLeaves := map[string]bool{}
+	var walk func(node *Node, path string)
+	walk = func(node *Node, path string) {
+		for _, clusterId := range node.ClusterIds {
+			if d.IdToCluster.Contains(clusterId) {
+				liveLeaves[path] = true
+				break
+			}
+		}
+		for key, child := range node.KeyToChildNode {
+			walk(child, path+"/"+strconv.Quote(key))
+		}
+	}
+	for key, child := range d.RootNode.KeyToChildNode {
+		walk(child, strconv.Quote(key))
+	}
+
+	for leaf := range leafStats {
+		if !liveLeaves[leaf] {
+			delete(leafStats, leaf)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package drain3
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestTokenCountSimTh(t *testing.T) {
+	strategy := NewTokenCountSimTh(3, 0.9, 8, 0.4)
+	require.Equal(t, 0.9, strategy.Threshold("", 2))
+	require.InDelta(t, 0.6, strategy.Threshold("", 6), 1e-9)
+	require.Equal(t, 0.4, strategy.Threshold("", 12))
+
+	drain, err := NewDrain(WithSimThStrategy(strategy))
+	require.NoError(t, err)
+
+	for _, log := range []string{
+		"disk a full",
+		"disk b full",
+		"request 1 served by worker a in time",
+		"request 2 served by worker b in 10ms",
+	} {
+		_, _, err := drain.AddLogMessage(log)
+		require.NoError(t, err)
+	}
+
+	// short messages differing by one token stay apart while long messages differing by half of their tokens are merged
+	templates := []string{}
+	for _, cluster := range drain.GetClusters() {
+		templates = append(templates, cluster.GetTemplate())
+	}
+	require.ElementsMatch(t, []string{"disk a full", "disk b full", "request <*> served by worker <*> in <*>"}, templates)
+}
+
+func TestLeafAdaptiveSimTh(t *testing.T) {
+	strategy := NewLeafAdaptiveSimTh(0.4, 0.9, 0.9)
+	drain, err := NewDrain(WithSimThStrategy(strategy))
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		_, _, err := drain.AddLogMessage("job started on node")
+		require.NoError(t, err)
+	}
+
+	// the leaf only saw identical messages, so a message differing by one of four tokens no longer joins
+	_, leaf := drain.searchLeafPath(drain.RootNode, "", drain.getContentAsTokens("job started on host"), true)
+	require.Equal(t, `"4"/"job"`, leaf)
+	require.Equal(t, 0.9, strategy.Threshold(leaf, 4))
+	require.Equal(t, 0.4, strategy.Threshold("", 4))
+
+	_, updateType, err := drain.AddLogMessage("job started on host")
+	require.NoError(t, err)
+	require.Equal(t, ClusterUpdateTypeCreated, updateType)
+	require.Equal(t, 2, drain.IdToCluster.Len())
+}
+
+func TestLeafAdaptiveSimThAfterLoad(t *testing.T) {
+	for _, codec := range []SnapshotCodec{NewJSONCodec(), NewBinaryCodec()} {
+		t.Run(fmt.Sprintf("%T", codec), func(t *testing.T) {
+			drain, err := NewDrain(WithSimThStrategy(NewLeafAdaptiveSimTh(0.4, 0.9, 0.9)), WithRebuildTreeOnLoad())
+			require.NoError(t, err)
+			persistence := NewMemoryPersistence()
+			miner := NewTemplateMiner(drain, persistence, WithSnapshotCodec(codec))
+
+			for i := 0; i < 5; i++ {
+				_, _, _, _, err := miner.AddLogMessage(context.Background(), "job started on node")
+				require.NoError(t, err)
+			}
+			require.NoError(t, miner.SaveState(context.Background()))
+
+			// a restarted miner gets the learned thresholds from the snapshot, and the rebuilt tree has the same leaf paths
+			strategy := NewLeafAdaptiveSimTh(0.4, 0.9, 0.9)
+			drain, err = NewDrain(WithSimThStrategy(strategy))
+			require.NoError(t, err)
+			miner = NewTemplateMiner(drain, persistence, WithSnapshotCodec(codec))
+			require.NoError(t, miner.LoadState(context.Background()))
+			require.Equal(t, map[string]*LeafSimStats{`"4"/"job"`: {Count: 4, Sum: 4}}, strategy.LeafStats())
+
+			_, _, _, _, err = miner.AddLogMessage(context.Background(), "job started on host")
+			require.NoError(t, err)
+			require.Len(t, strategy.LeafStats(), 1)
+			require.Equal(t, 2, miner.drain.IdToCluster.Len())
+		})
+	}
+}
+
+func TestLeafAdaptiveSimThJudgesByPreviousMessages(t *testing.T) {
+	strategy := NewLeafAdaptiveSimTh(0.4, 0.95, 1.2)
+	drain, err := NewDrain(WithSimThStrategy(strategy))
+	require.NoError(t, err)
+
+	for _, log := range []string{"job started on node", "job started on host"} {
+		_, _, err := drain.AddLogMessage(log)
+		require.NoError(t, err)
+	}
+
+	// the second message is judged by the minimum threshold, not by the mean of its own similarity
+	require.Equal(t, 1, drain.IdToCluster.Len())
+	require.InDelta(t, 0.9, strategy.Threshold(`"4"/"job"`, 4), 0.0001)
+}
+
+func TestLeafAdaptiveSimThForgetsDeadLeaves(t *testing.T) {
+	strategy := NewLeafAdaptiveSimTh(0.4, 0.9, 0.9)
+	drain, err := NewDrain(WithSimThStrategy(strategy), WithMaxCluster(2))
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 2; j++ {
+			_, _, err := drain.AddLogMessage(fmt.Sprintf("job%c started", 'a'+i))
+			require.NoError(t, err)
+		}
+		require.LessOrEqual(t, len(strategy.LeafStats()), 4)
+	}
+	require.Contains(t, strategy.LeafStats(), `"2"/"jobj"`)
+}
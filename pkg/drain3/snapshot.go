@@ -0,0 +1,80 @@
+package drain3
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// SnapshotVersion is the schema version written by Drain.MarshalJSON.
+// snapshots written before versioning was introduced carry no version field and are treated as version 0
+const SnapshotVersion = 1
+
+const snapshotVersionField = "Version"
+
+type snapshotMigration func(state map[string]json.RawMessage) error
+
+// snapshotMigrations upgrades a snapshot of the keyed version to the next version.
+// every schema change must bump SnapshotVersion, register a migration here and add a golden file under testdata
+var snapshotMigrations = map[int]snapshotMigration{
+	0: migrateSnapshotV0ToV1,
+}
+
+func migrateSnapshot(state []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(state, &fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal snapshot: %w", err)
+	}
+
+	version, err := getSnapshotVersion(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	if version > SnapshotVersion {
+		return nil, fmt.Errorf("snapshot version %d is newer than supported version %d", version, SnapshotVersion)
+	} else if version == SnapshotVersion {
+		return state, nil
+	}
+
+	for version < SnapshotVersion {
+		migration, exist := snapshotMigrations[version]
+		if !exist {
+			return nil, fmt.Errorf("no migration registered for snapshot version %d", version)
+		}
+
+		if err := migration(fields); err != nil {
+			return nil, fmt.Errorf("failed to migrate snapshot from version %d: %w", version, err)
+		}
+
+		version++
+		fields[snapshotVersionField] = json.RawMessage(strconv.Itoa(version))
+	}
+
+	return json.Marshal(fields)
+}
+
+func getSnapshotVersion(fields map[string]json.RawMessage) (int, error) {
+	rawVersion, exist := fields[snapshotVersionField]
+	if !exist {
+		return 0, nil
+	}
+
+	var version int
+	if err := json.Unmarshal(rawVersion, &version); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal snapshot version: %w", err)
+	}
+
+	return version, nil
+}
+
+func migrateSnapshotV0ToV1(state map[string]json.RawMessage) error {
+	// version 1 introduced the version field along with the options added since, which are all off in older snapshots.
+	// VariableParamStr needs its default even while variable length templates are off
+	rawVariableParamStr, err := json.Marshal(defaultVariableParamStr)
+	if err != nil {
+		return fmt.Errorf("failed to marshal variable param: %w", err)
+	}
+	state["VariableParamStr"] = rawVariableParamStr
+	return nil
+}
@@ -0,0 +1,102 @@
+package drain3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestLoadStateGoldenSnapshots(t *testing.T) {
+	expectedTemplates := []string{
+		"connected to <*>",
+		"Deleted log <*> (kafka.log.LogSegment)",
+		"user <*> logged in",
+		"disk full",
+	}
+	sort.Strings(expectedTemplates)
+
+	// every historical snapshot version must have a golden file
+	for version := 0; version <= SnapshotVersion; version++ {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			state, err := os.ReadFile(filepath.Join("testdata", fmt.Sprintf("snapshot_v%d.json", version)))
+			require.NoError(t, err)
+
+			drain, err := NewDrain()
+			require.NoError(t, err)
+
+			persistence := NewMemoryPersistence()
+			persistence.State = state
+			miner := NewTemplateMiner(drain, persistence)
+			require.NoError(t, miner.LoadState(context.Background()))
+
+			templates := []string{}
+			for _, cluster := range miner.drain.GetClusters() {
+				templates = append(templates, cluster.GetTemplate())
+			}
+			sort.Strings(templates)
+			require.Equal(t, expectedTemplates, templates)
+			require.Equal(t, int64(4), miner.drain.ClustersCounter)
+			require.Equal(t, []string{"_"}, miner.drain.ExtraDelimiters)
+
+			cluster, err := miner.Match("user carol logged in", SearchStrategyNever)
+			require.NoError(t, err)
+			require.NotNil(t, cluster)
+			require.Equal(t, int64(2), cluster.Size)
+
+			// a migrated snapshot is saved with the current version
+			require.NoError(t, miner.SaveState(context.Background()))
+			var saved SerializableDrain
+			require.NoError(t, json.Unmarshal(persistence.State, &saved))
+			require.Equal(t, SnapshotVersion, saved.Version)
+		})
+	}
+}
+
+func TestLoadStateRejectsNewerSnapshot(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+
+	persistence := NewMemoryPersistence()
+	persistence.State = []byte(fmt.Sprintf(`{"Version":%d}`, SnapshotVersion+1))
+	miner := NewTemplateMiner(drain, persistence)
+	require.Error(t, miner.LoadState(context.Background()))
+}
+
+func TestLoadStatePreservesRecencyOrder(t *testing.T) {
+	drain, err := NewDrain(WithMaxCluster(3))
+	require.NoError(t, err)
+
+	for _, log := range []string{"alpha one", "beta two", "gamma three", "alpha one"} {
+		_, _, err := drain.AddLogMessage(log)
+		require.NoError(t, err)
+	}
+
+	// matching only tests candidates and must not change the recency order
+	_, err = drain.Match("beta two", SearchStrategyAlways)
+	require.NoError(t, err)
+
+	for _, codec := range []SnapshotCodec{NewJSONCodec(), NewBinaryCodec()} {
+		t.Run(fmt.Sprintf("%T", codec), func(t *testing.T) {
+			state, err := codec.Marshal(drain)
+			require.NoError(t, err)
+
+			loaded, err := codec.Unmarshal(state)
+			require.NoError(t, err)
+			require.Equal(t, []int64{2, 3, 1}, loaded.IdToCluster.Keys())
+
+			cluster, exist := loaded.IdToCluster.Peek(1)
+			require.True(t, exist)
+			require.False(t, cluster.LastAccessTime.IsZero())
+
+			// the least recently used cluster is evicted, as it would have been without the restart
+			_, _, err = loaded.AddLogMessage("delta four")
+			require.NoError(t, err)
+			require.Equal(t, []int64{3, 1, 4}, loaded.IdToCluster.Keys())
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package drain3
+
+import (
+	"math"
+)
+
+// TokenWeigher weighs the tokens compared by the similarity of a template and a message,
+// so that the similarity focuses on the informative tokens
+type TokenWeigher interface {
+	// Weight returns the weight of the template token at the position of a template of tokenCount tokens
+	Weight(position, tokenCount int, token string) float64
+	// Observe receives the tokens of every message added to drain
+	Observe(tokens []string)
+}
+
+// PositionalDecayWeigher weighs leading tokens, usually verbs and components, more than trailing ones:
+// the weight of a token is decay to the power of its position
+type PositionalDecayWeigher struct {
+	decay float64
+}
+
+func NewPositionalDecayWeigher(decay float64) *PositionalDecayWeigher {
+	return &PositionalDecayWeigher{decay: decay}
+}
+
+func (w *PositionalDecayWeigher) Weight(position, _ int, _ string) float64 {
+	return math.Pow(w.decay, float64(position))
+}
+
+func (w *PositionalDecayWeigher) Observe(_ []string) {}
+
+// IDFWeigher weighs template tokens by their inverse document frequency learned from the stream,
+// so that tokens found in most messages (log levels, common words) count less than distinctive ones.
+// tokens which were never observed or were dropped, the rarest ones first, weigh as much as a token seen once,
+// while template parameters, which vary by definition, weigh 1 like tokens found in every message.
+// at most maxTokens distinct tokens are tracked to bound memory: once full, every count decays by half until a quarter of the tokens
+// is dropped, which makes room for new tokens at the expense of the rarest ones. learned weights are not persisted
+type IDFWeigher struct {
+	maxTokens      int
+	messageCount   int64
+	tokenToMessage map[string]int64
+	paramTokens    map[string]bool
+}
+
+// paramAwareWeigher is a TokenWeigher which needs the parameter tokens of the drain to tell them from unknown tokens
+type paramAwareWeigher interface {
+	setParamTokens(paramTokens ...string)
+}
+
+// setTokenWeigherParams tells the token weigher the parameter tokens of the drain if it needs them
+func (d *Drain) setTokenWeigherParams() {
+	if weigher, ok := d.TokenWeigher.(paramAwareWeigher); ok {
+		weigher.setParamTokens(d.ParamStr, d.VariableParamStr)
+	}
+}
+
+func NewIDFWeigher(maxTokens int) *IDFWeigher {
+	return &IDFWeigher{
+		maxTokens:      maxTokens,
+		tokenToMessage: map[string]int64{},
+	}
+}
+
+func (w *IDFWeigher) setParamTokens(paramTokens ...string) {
+	w.paramTokens = map[string]bool{}
+	for _, paramToken := range paramTokens {
+		w.paramTokens[paramToken] = true
+	}
+}
+
+func (w *IDFWeigher) Weight(_, _ int, token string) float64 {
+	if w.paramTokens[token] {
+		return 1
+	}
+
+	// a token which is not tracked is at most as frequent as the rarest tracked ones
+	messageCount := w.tokenToMessage[token]
+	return 1 + math.Log(float64(1+w.messageCount)/float64(1+messageCount))
+}
+
+func (w *IDFWeigher) Observe(tokens []string) {
+	distinctTokens := map[string]bool{}
+	newTokens := 0
+	for _, token := range tokens {
+		if distinctTokens[token] {
+			continue
+		}
+		distinctTokens[token] = true
+
+		if _, exist := w.tokenToMessage[token]; !exist {
+			newTokens++
+		}
+	}
+
+	// counts decay between messages, so that no token is counted in more messages than were observed
+	if newTokens > 0 && len(w.tokenToMessage)+newTokens > w.maxTokens {
+		w.decay()
+	}
+
+	w.messageCount++
+	for token := range distinctTokens {
+		if _, exist := w.tokenToMessage[token]; exist || len(w.tokenToMessage) < w.maxTokens {
+			w.tokenToMessage[token]++
+		}
+	}
+}
+
+// decay halves the counts of the tokens and of the messages, so that weights keep their ratios,
+// until at most three quarters of maxTokens tokens are left
+func (w *IDFWeigher) decay() {
+	for len(w.tokenToMessage) > w.maxTokens*3/4 {
+		for token, messageCount := range w.tokenToMessage {
+			if messageCount/2 == 0 {
+				delete(w.tokenToMessage, token)
+			} else {
+				w.tokenToMessage[token] = messageCount / 2
+			}
+		}
+		w.messageCount /= 2
+	}
+}
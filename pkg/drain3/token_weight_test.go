@@ -0,0 +1,93 @@
+package drain3
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestTokenWeighers(t *testing.T) {
+	// the six kinds of events of the kafka fixtures, "offset" and "time" index deletions being told apart
+	expectedTemplates := []string{
+		"[ProducerStateManager partition=  consumer <*> Writing producer snapshot at offset <*> (kafka.log.ProducerStateManager)",
+		"[Log partition=  consumer offsets-48, dir=/home1/irteam/apps/data/kafka/kafka-logs] Deleting segment files <*> <*> <*> <*> (kafka.log.Log)",
+		"Deleted log /home1/irteam/apps/data/kafka/kafka-logs/  consumer <*> (kafka.log.LogSegment)",
+		"Deleted offset index /home1/irteam/apps/data/kafka/kafka-logs/  consumer <*> (kafka.log.LogSegment)",
+		"Deleted time index /home1/irteam/apps/data/kafka/kafka-logs/  consumer <*> (kafka.log.LogSegment)",
+		"[Log partition=  consumer <*> dir=/home1/irteam/apps/data/kafka/kafka-logs] Rolled new log segment at offset <*> in <*> ms. (kafka.log.Log)",
+	}
+
+	testCases := []struct {
+		name             string
+		simTh            float64
+		weigher          TokenWeigher
+		expectedClusters int
+	}{
+		// every token weighing the same, the trailing variable tokens of segment deletions keep them apart
+		{"unweighted", 0.8, nil, 9},
+		{"positional decay", 0.8, NewPositionalDecayWeigher(0.5), 6},
+		// the frequent "Deleted" and path tokens weigh less than the distinctive "offset" and "time"
+		{"idf", 0.7, NewIDFWeigher(1000), 6},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			drain, err := NewDrain(WithExtraDelimiter([]string{"_"}), WithSimTh(testCase.simTh), WithTokenWeigher(testCase.weigher))
+			require.NoError(t, err)
+
+			for _, log := range kafkaLogs {
+				_, _, err := drain.AddLogMessage(log)
+				require.NoError(t, err)
+			}
+
+			clusters := drain.GetClusters()
+			require.Len(t, clusters, testCase.expectedClusters)
+			if testCase.weigher == nil {
+				return
+			}
+
+			templates := []string{}
+			for _, cluster := range clusters {
+				templates = append(templates, cluster.GetTemplate())
+			}
+			require.ElementsMatch(t, expectedTemplates, templates)
+		})
+	}
+}
+
+func TestIDFWeigher(t *testing.T) {
+	weigher := NewIDFWeigher(10)
+	_, err := NewDrain(WithTokenWeigher(weigher))
+	require.NoError(t, err)
+	weigher.Observe([]string{"INFO", "user", "alice"})
+	weigher.Observe([]string{"INFO", "user", "bob", "bob"})
+
+	require.Equal(t, float64(1), weigher.Weight(0, 3, "INFO"))
+	require.Greater(t, weigher.Weight(2, 3, "alice"), weigher.Weight(1, 3, "user"))
+	require.Equal(t, weigher.Weight(2, 3, "alice"), weigher.Weight(2, 3, "bob"))
+
+	// a token never observed is as informative as the rarest ones, not as common as INFO
+	require.Equal(t, 1+math.Log(3), weigher.Weight(2, 3, "carol"))
+	require.Greater(t, weigher.Weight(2, 3, "carol"), weigher.Weight(1, 3, "user"))
+
+	// parameters of the templates of the drain vary, so they are no more informative than INFO
+	require.Equal(t, float64(1), weigher.Weight(2, 3, "<*>"))
+}
+
+func TestIDFWeigherDecay(t *testing.T) {
+	weigher := NewIDFWeigher(10)
+	for i := 0; i < 1000; i++ {
+		weigher.Observe([]string{"INFO", "request", fmt.Sprint(i)})
+		require.LessOrEqual(t, len(weigher.tokenToMessage), 10)
+	}
+
+	// tokens showing up once the limit was reached are still learned
+	for i := 0; i < 5; i++ {
+		weigher.Observe([]string{"ERROR", "request", fmt.Sprint(i)})
+	}
+	require.LessOrEqual(t, len(weigher.tokenToMessage), 10)
+	require.Less(t, weigher.Weight(1, 3, "request"), weigher.Weight(0, 3, "INFO"))
+	require.Greater(t, weigher.Weight(0, 3, "ERROR"), weigher.Weight(0, 3, "INFO"))
+	require.InDelta(t, 1, weigher.Weight(1, 3, "request"), 0.01)
+}
@@ -0,0 +1,173 @@
+package drain3
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// LineIterator yields the lines to train on, *bufio.Scanner satisfies it
+type LineIterator interface {
+	Scan() bool
+	Text() string
+	Err() error
+}
+
+type TrainResult struct {
+	// final cluster id of every line in input order, 0 for lines whose cluster was evicted
+	ClusterIds []int64
+	// ids of the clusters merged away mapped to the id of the cluster they were merged into
+	MergedClusterIds map[int64]int64
+	// ids of the clusters created by the first pass which no line was re-assigned to, those are removed
+	RemovedClusterIds []int64
+}
+
+type trainOptions struct {
+	mergeIdenticalTemplates bool
+}
+
+type trainOptionFn func(*trainOptions)
+
+// WithMergeIdenticalTemplates merges clusters which ended up with the same template,
+// e.g. because their first messages were routed to different prefix tree leaves
+func WithMergeIdenticalTemplates() trainOptionFn {
+	return func(options *trainOptions) {
+		options.mergeIdenticalTemplates = true
+	}
+}
+
+// Train mines a whole batch of lines in two passes.
+// the first pass mines the lines as AddLogMessage would, learning parameter types and slot statistics alike,
+// the second one re-assigns every line to the best matching final cluster so that the result does not depend on the order of the lines, and cluster sizes are corrected accordingly.
+// clusters left without lines by the correction are removed, so that they do not count as rare templates.
+// lines are kept in memory between the passes, and the state is saved once at the end
+func (m *TemplateMiner) Train(ctx context.Context, iterator LineIterator, options ...trainOptionFn) (*TrainResult, error) {
+	trainOptions := &trainOptions{}
+	for _, option := range options {
+		option(trainOptions)
+	}
+
+	contents := []string{}
+	firstPassClusterIds := []int64{}
+	for iterator.Scan() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		content := iterator.Text()
+		if m.headerParser != nil {
+			var err error
+			_, content, err = m.headerParser.Parse(content)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse header of line %d: %w", len(contents)+1, err)
+			}
+		}
+
+		result, _, err := m.mineWithoutSave("", content, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add line %d: %w", len(contents)+1, err)
+		}
+
+		contents = append(contents, content)
+		firstPassClusterIds = append(firstPassClusterIds, result.Cluster.ClusterId)
+	}
+	if err := iterator.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read lines: %w", err)
+	}
+
+	mergedClusterIds := map[int64]int64{}
+	if trainOptions.mergeIdenticalTemplates {
+		mergedClusterIds = m.drain.mergeIdenticalTemplates()
+	}
+
+	// sizes are corrected by the difference between the lines assigned in the first and in the second pass
+	sizeDeltas := map[int64]int64{}
+	for _, clusterId := range firstPassClusterIds {
+		if mergedId, merged := mergedClusterIds[clusterId]; merged {
+			clusterId = mergedId
+		}
+		sizeDeltas[clusterId]--
+	}
+
+	clusterIds := make([]int64, 0, len(contents))
+	for _, content := range contents {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		cluster, err := m.drain.Match(content, SearchStrategyAlways)
+		if err != nil {
+			return nil, fmt.Errorf("failed to match: %w", err)
+		}
+
+		clusterId := int64(0)
+		if cluster != nil {
+			clusterId = cluster.ClusterId
+			sizeDeltas[clusterId]++
+		}
+		clusterIds = append(clusterIds, clusterId)
+	}
+
+	removedClusterIds := []int64{}
+	for clusterId, sizeDelta := range sizeDeltas {
+		cluster, exist := m.drain.IdToCluster.Peek(clusterId)
+		if !exist {
+			continue
+		}
+
+		cluster.Size += sizeDelta
+		if cluster.Size <= 0 {
+			m.drain.removeFromPrefixTree(cluster)
+			m.drain.IdToCluster.Remove(clusterId)
+			removedClusterIds = append(removedClusterIds, clusterId)
+		}
+	}
+	sort.Slice(removedClusterIds, func(i, j int) bool { return removedClusterIds[i] < removedClusterIds[j] })
+
+	if err := m.SaveState(ctx); err != nil {
+		return nil, fmt.Errorf("failed to save state: %w", err)
+	}
+
+	return &TrainResult{
+		ClusterIds:        clusterIds,
+		MergedClusterIds:  mergedClusterIds,
+		RemovedClusterIds: removedClusterIds,
+	}, nil
+}
+
+// mergeIdenticalTemplates merges every cluster into the cluster of the lowest id having the same template in the same partition
+// and returns the ids of the merged clusters mapped to the id they were merged into
+func (d *Drain) mergeIdenticalTemplates() map[int64]int64 {
+	clusterIds := d.IdToCluster.Keys()
+	sort.Slice(clusterIds, func(i, j int) bool { return clusterIds[i] < clusterIds[j] })
+
+	mergedClusterIds := map[int64]int64{}
+	templateToCluster := map[string]*LogCluster{}
+	for _, clusterId := range clusterIds {
+		cluster, exist := d.IdToCluster.Peek(clusterId)
+		if !exist {
+			continue
+		}
+
+		template := cluster.Partition + "/" + cluster.GetTemplate()
+		target, exist := templateToCluster[template]
+		if !exist {
+			templateToCluster[template] = cluster
+			continue
+		}
+
+		target.Size += cluster.Size
+		target.ParamSlots = mergeParamSlots(target.ParamSlots, cluster.ParamSlots)
+		for _, sample := range cluster.Samples {
+			target.addSample(sample, d.MaxClusterSamples)
+		}
+		if cluster.LastAccessTime.After(target.LastAccessTime) {
+			target.LastAccessTime = cluster.LastAccessTime
+		}
+		d.removeFromPrefixTree(cluster)
+		d.IdToCluster.Remove(cluster.ClusterId)
+		mergedClusterIds[cluster.ClusterId] = target.ClusterId
+	}
+
+	return mergedClusterIds
+}
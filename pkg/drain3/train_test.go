@@ -0,0 +1,78 @@
+package drain3
+
+import (
+	"bufio"
+	"context"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestTrain(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence())
+
+	// the first line gets its own leaf before the numeric lines create the more general cluster
+	lines := []string{"alpha x y", "1 x y", "2 x y", "disk full"}
+	result, err := miner.Train(context.Background(), bufio.NewScanner(strings.NewReader(strings.Join(lines, "\n"))))
+	require.NoError(t, err)
+	require.Equal(t, []int64{2, 2, 2, 3}, result.ClusterIds)
+	require.Empty(t, result.MergedClusterIds)
+	require.Equal(t, []int64{1}, result.RemovedClusterIds)
+
+	sizes := map[string]int64{}
+	for _, cluster := range drain.GetClusters() {
+		sizes[cluster.GetTemplate()] = cluster.Size
+	}
+	require.Equal(t, map[string]int64{"<*> x y": 3, "disk full": 1}, sizes)
+	require.True(t, drain.CheckConsistency().IsConsistent())
+
+	// lines of a removed cluster are matched by the cluster they were re-assigned to
+	cluster, err := drain.Match("alpha x y", SearchStrategyFallback)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), cluster.ClusterId)
+}
+
+func TestTrainObservesParamSlots(t *testing.T) {
+	drain, err := NewDrain()
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence(), WithParamTypeLearning(), WithParamSlotStats(5))
+
+	_, err = miner.Train(context.Background(), bufio.NewScanner(strings.NewReader("took 1 ms\ntook 2 ms\ntook 3 ms")))
+	require.NoError(t, err)
+
+	cluster, exist := drain.IdToCluster.Peek(1)
+	require.True(t, exist)
+	require.Equal(t, "took <*> ms", cluster.GetTemplate())
+	require.Equal(t, ParamTypeDuration, cluster.ParamSlots[1].DominantType())
+	require.Equal(t, []*ValueCount{{Value: "2", Count: 1}, {Value: "3", Count: 1}}, cluster.ParamSlots[1].TopValues.Top(5))
+}
+
+func TestTrainMergesIdenticalTemplates(t *testing.T) {
+	drain, err := NewDrain(WithMaxClusterSamples(5))
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence())
+
+	for _, log := range []string{"alpha x y", "1 x y", "2 x y"} {
+		_, _, err := drain.AddLogMessage(log)
+		require.NoError(t, err)
+	}
+
+	// clusters of different leaves end up with the same template, e.g. after being restored from another tool
+	cluster, exist := drain.IdToCluster.Peek(1)
+	require.True(t, exist)
+	cluster.LogTemplateTokens = []string{"<*>", "x", "y"}
+
+	result, err := miner.Train(context.Background(), bufio.NewScanner(strings.NewReader("3 x y\nbeta x y")), WithMergeIdenticalTemplates())
+	require.NoError(t, err)
+	require.Equal(t, map[int64]int64{2: 1}, result.MergedClusterIds)
+	require.Equal(t, []int64{1, 1}, result.ClusterIds)
+	require.Empty(t, result.RemovedClusterIds)
+
+	clusters := drain.GetClusters()
+	require.Len(t, clusters, 1)
+	require.Equal(t, int64(5), clusters[0].Size)
+	require.Equal(t, []string{"alpha x y", "1 x y", "2 x y", "3 x y", "beta x y"}, clusters[0].Samples)
+	require.True(t, drain.CheckConsistency().IsConsistent())
+}
@@ -0,0 +1,327 @@
+package drain3
+
+import "sort"
+
+const defaultVariableParamStr = "<*...>"
+
+type alignmentOp int
+
+const (
+	alignmentOpMatch        alignmentOp = iota // template literal equals the message token
+	alignmentOpParam                           // template parameter matches a single message token
+	alignmentOpAbsorb                          // template variable parameter absorbs a message token
+	alignmentOpSkipTemplate                    // template token has no counterpart in the message
+	alignmentOpSkipMessage                     // message token has no counterpart in the template
+)
+
+type alignmentStep struct {
+	op            alignmentOp
+	templateIndex int
+	messageIndex  int
+}
+
+type alignment struct {
+	steps []alignmentStep
+	sim   float64
+	// count of template parameters aligned to a message token
+	paramCount int64
+}
+
+func (d *Drain) isVariableParam(token string) bool {
+	return d.VariableLength && token == d.VariableParamStr
+}
+
+func (d *Drain) isParam(token string) bool {
+	return token == d.ParamStr || d.isVariableParam(token)
+}
+
+func (d *Drain) hasVariableParam(tokens []string) bool {
+	for _, token := range tokens {
+		if d.isVariableParam(token) {
+			return true
+		}
+	}
+	return false
+}
+
+// alignTokens aligns a template against the message tokens, maximizing the matched literals (LCS)
+// while letting parameters match a single token and variable parameters absorb any number of tokens
+func (d *Drain) alignTokens(template []string, tokens []string, includeParams bool) *alignment {
+	const (
+		matchScore = 2
+		paramScore = 1
+	)
+
+	rows, cols := len(template)+1, len(tokens)+1
+	scores := make([][]int, rows)
+	for i := range scores {
+		scores[i] = make([]int, cols)
+	}
+
+	for i := 1; i < rows; i++ {
+		for j := 1; j < cols; j++ {
+			best := max(scores[i-1][j], scores[i][j-1])
+			if d.isVariableParam(template[i-1]) {
+				best = max(best, scores[i-1][j-1])
+			} else if template[i-1] == d.ParamStr {
+				best = max(best, scores[i-1][j-1]+paramScore)
+			} else if template[i-1] == tokens[j-1] {
+				best = max(best, scores[i-1][j-1]+matchScore)
+			}
+			scores[i][j] = best
+		}
+	}
+
+	// walk back from the end, preferring matches over skips
+	steps := []alignmentStep{}
+	i, j := len(template), len(tokens)
+	for i > 0 || j > 0 {
+		switch {
+		case i > 0 && j > 0 && template[i-1] == tokens[j-1] && !d.isParam(template[i-1]) && scores[i][j] == scores[i-1][j-1]+matchScore:
+			steps = append(steps, alignmentStep{op: alignmentOpMatch, templateIndex: i - 1, messageIndex: j - 1})
+			i, j = i-1, j-1
+		case i > 0 && j > 0 && template[i-1] == d.ParamStr && scores[i][j] == scores[i-1][j-1]+paramScore:
+			steps = append(steps, alignmentStep{op: alignmentOpParam, templateIndex: i - 1, messageIndex: j - 1})
+			i, j = i-1, j-1
+		case i > 0 && j > 0 && d.isVariableParam(template[i-1]) && scores[i][j] == scores[i][j-1]:
+			steps = append(steps, alignmentStep{op: alignmentOpAbsorb, templateIndex: i - 1, messageIndex: j - 1})
+			j--
+		case i > 0 && j > 0 && d.isVariableParam(template[i-1]) && scores[i][j] == scores[i-1][j-1]:
+			steps = append(steps, alignmentStep{op: alignmentOpAbsorb, templateIndex: i - 1, messageIndex: j - 1})
+			i, j = i-1, j-1
+		case i > 0 && scores[i][j] == scores[i-1][j]:
+			steps = append(steps, alignmentStep{op: alignmentOpSkipTemplate, templateIndex: i - 1, messageIndex: -1})
+			i--
+		default:
+			steps = append(steps, alignmentStep{op: alignmentOpSkipMessage, templateIndex: -1, messageIndex: j - 1})
+			j--
+		}
+	}
+
+	for left, right := 0, len(steps)-1; left < right; left, right = left+1, right-1 {
+		steps[left], steps[right] = steps[right], steps[left]
+	}
+
+	matchCount, paramCount, absorbedCount, variableParamCount := 0, 0, 0, 0
+	for _, step := range steps {
+		switch step.op {
+		case alignmentOpMatch:
+			matchCount++
+		case alignmentOpParam:
+			paramCount++
+		case alignmentOpAbsorb:
+			absorbedCount++
+		}
+	}
+	for _, token := range template {
+		if d.isVariableParam(token) {
+			variableParamCount++
+		}
+	}
+
+	// tokens absorbed by variable parameters are not compared, so they count neither as matches nor as mismatches
+	length := max(len(template)-variableParamCount, len(tokens)-absorbedCount)
+	simTokens := matchCount
+	if includeParams {
+		simTokens += paramCount
+	}
+
+	sim := 1.0
+	if length > 0 {
+		sim = float64(simTokens) / float64(length)
+	}
+
+	return &alignment{
+		steps:      steps,
+		sim:        sim,
+		paramCount: int64(paramCount),
+	}
+}
+
+// createAlignedTemplate merges the message into the template along their alignment.
+// runs of unmatched tokens become a single parameter per token when both sides have as many tokens,
+// and a variable parameter otherwise
+func (d *Drain) createAlignedTemplate(template []string, tokens []string, alignment *alignment) []string {
+	newTemplate := []string{}
+
+	gapTemplateTokens, gapMessageTokens, gapHasVariableParam := 0, 0, false
+	flushGap := func() {
+		if gapHasVariableParam || gapTemplateTokens != gapMessageTokens {
+			if len(newTemplate) == 0 || newTemplate[len(newTemplate)-1] != d.VariableParamStr {
+				newTemplate = append(newTemplate, d.VariableParamStr)
+			}
+		} else {
+			for k := 0; k < gapTemplateTokens; k++ {
+				newTemplate = append(newTemplate, d.ParamStr)
+			}
+		}
+		gapTemplateTokens, gapMessageTokens, gapHasVariableParam = 0, 0, false
+	}
+
+	for _, step := range alignment.steps {
+		switch step.op {
+		case alignmentOpMatch:
+			flushGap()
+			newTemplate = append(newTemplate, template[step.templateIndex])
+		case alignmentOpParam:
+			flushGap()
+			newTemplate = append(newTemplate, d.ParamStr)
+		case alignmentOpAbsorb:
+			gapHasVariableParam = true
+			gapMessageTokens++
+		case alignmentOpSkipTemplate:
+			if d.isVariableParam(template[step.templateIndex]) {
+				gapHasVariableParam = true
+			} else {
+				gapTemplateTokens++
+			}
+		case alignmentOpSkipMessage:
+			gapMessageTokens++
+		}
+	}
+	flushGap()
+
+	return newTemplate
+}
+
+// alignmentSearch finds the best cluster for the tokens among templates of neighbouring lengths and templates having variable parameters.
+// it is only used when the prefix tree, which is keyed by token count, found no match. templates of the same length without
+// a variable parameter are left out, as the prefix tree already tried the leaf the tokens belong to.
+// candidates are narrowed by an upper bound of their similarity before the quadratic alignment
+func (d *Drain) alignmentSearch(partition string, tokens []string, simTh float64, includeParams bool) (*LogCluster, *alignment) {
+	var bestCluster *LogCluster
+	var bestAlignment *alignment
+
+	tokenCounts := make(map[string]int, len(tokens))
+	for _, token := range tokens {
+		tokenCounts[token]++
+	}
+
+	for _, clusterId := range d.alignmentCandidates(partition, len(tokens)) {
+		cluster, exist := d.IdToCluster.Peek(clusterId)
+		if !exist {
+			continue
+		}
+
+		// a candidate can tie the best alignment and still win on its parameter count
+		simBound := d.alignmentSimBound(cluster.LogTemplateTokens, tokens, tokenCounts, includeParams)
+		if simBound < simTh || (bestAlignment != nil && simBound < bestAlignment.sim) {
+			continue
+		}
+
+		currentAlignment := d.alignTokens(cluster.LogTemplateTokens, tokens, includeParams)
+		if bestAlignment == nil || currentAlignment.sim > bestAlignment.sim ||
+			(currentAlignment.sim == bestAlignment.sim && currentAlignment.paramCount > bestAlignment.paramCount) {
+			bestCluster = cluster
+			bestAlignment = currentAlignment
+		}
+	}
+
+	if bestAlignment == nil || bestAlignment.sim < simTh {
+		return nil, nil
+	}
+
+	return bestCluster, bestAlignment
+}
+
+// alignmentCandidates returns the ids of the clusters of the partition whose templates are at most MaxLengthDelta tokens longer
+// or shorter than tokenCount without being as long, followed by the clusters with variable parameters, each in creation order
+func (d *Drain) alignmentCandidates(partition string, tokenCount int) []int64 {
+	clusterIds := []int64{}
+	for delta := 1; int64(delta) <= d.MaxLengthDelta; delta++ {
+		if tokenCount-delta > 0 {
+			clusterIds = append(clusterIds, d.getClustersIdsForSeqLen(partition, tokenCount-delta)...)
+		}
+		clusterIds = append(clusterIds, d.getClustersIdsForSeqLen(partition, tokenCount+delta)...)
+	}
+	sort.Slice(clusterIds, func(i, j int) bool {
+		return clusterIds[i] < clusterIds[j]
+	})
+
+	variableParamClusterIds := []int64{}
+	for clusterId := range d.variableParamClusterIds {
+		cluster, exist := d.IdToCluster.Peek(clusterId)
+		if !exist || !d.hasVariableParam(cluster.LogTemplateTokens) {
+			delete(d.variableParamClusterIds, clusterId)
+			continue
+		}
+
+		lengthDelta := int64(len(cluster.LogTemplateTokens) - tokenCount)
+		if lengthDelta < 0 {
+			lengthDelta = -lengthDelta
+		}
+		if cluster.Partition == partition && (lengthDelta == 0 || lengthDelta > d.MaxLengthDelta) {
+			variableParamClusterIds = append(variableParamClusterIds, clusterId)
+		}
+	}
+	sort.Slice(variableParamClusterIds, func(i, j int) bool {
+		return variableParamClusterIds[i] < variableParamClusterIds[j]
+	})
+
+	return append(clusterIds, variableParamClusterIds...)
+}
+
+// trackVariableParam records the cluster as an alignment candidate for every length if its template has a variable parameter
+func (d *Drain) trackVariableParam(cluster *LogCluster) {
+	if !d.hasVariableParam(cluster.LogTemplateTokens) {
+		return
+	}
+
+	if d.variableParamClusterIds == nil {
+		d.variableParamClusterIds = map[int64]struct{}{}
+	}
+	d.variableParamClusterIds[cluster.ClusterId] = struct{}{}
+}
+
+// alignmentSimBound returns an upper bound of the similarity alignTokens computes in linear time.
+// literals can only match the tokens they share with the message, and every template token which is not a variable parameter
+// is compared, as is every message token when there is no variable parameter to absorb it
+func (d *Drain) alignmentSimBound(template []string, tokens []string, tokenCounts map[string]int, includeParams bool) float64 {
+	sharedCounts := map[string]int{}
+	simTokens, paramCount, variableParamCount := 0, 0, 0
+	for _, token := range template {
+		if d.isVariableParam(token) {
+			variableParamCount++
+		} else if token == d.ParamStr {
+			paramCount++
+		} else if sharedCounts[token] < tokenCounts[token] {
+			sharedCounts[token]++
+			simTokens++
+		}
+	}
+	if includeParams {
+		simTokens += paramCount
+	}
+
+	length := len(template) - variableParamCount
+	if variableParamCount == 0 {
+		length = max(length, len(tokens))
+	}
+	if length == 0 {
+		return 1
+	}
+
+	return min(float64(simTokens)/float64(length), 1)
+}
+
+// removeFromPrefixTree removes a cluster id from every node under the partition and token count of its current template
+func (d *Drain) removeFromPrefixTree(cluster *LogCluster) {
+	var removeRecursive func(node *Node)
+	removeRecursive = func(node *Node) {
+		clusterIds := []int64{}
+		for _, id := range node.ClusterIds {
+			if id != cluster.ClusterId {
+				clusterIds = append(clusterIds, id)
+			}
+		}
+		node.ClusterIds = clusterIds
+
+		for _, child := range node.KeyToChildNode {
+			removeRecursive(child)
+		}
+	}
+
+	if node, exist := d.RootNode.KeyToChildNode[firstLayerKey(cluster.Partition, len(cluster.LogTemplateTokens))]; exist {
+		removeRecursive(node)
+	}
+}
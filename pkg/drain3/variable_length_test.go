@@ -0,0 +1,144 @@
+package drain3
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestVariableLengthTemplates(t *testing.T) {
+	drain, err := NewDrain(WithVariableLength(2))
+	require.NoError(t, err)
+	miner := NewTemplateMiner(drain, NewMemoryPersistence())
+
+	logs := []string{
+		"user John logged in from web",
+		"user John Smith logged in from web",
+		"user Anne Marie Jones logged in from web",
+		"user logged in from web",
+		"disk full",
+	}
+	for _, log := range logs {
+		_, _, err := drain.AddLogMessage(log)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 2, drain.IdToCluster.Len())
+	require.True(t, drain.CheckConsistency().IsConsistent())
+
+	expectedParameters := []string{"John", "John Smith", "Anne Marie Jones", ""}
+	for i, log := range logs[:4] {
+		cluster, err := drain.Match(log, SearchStrategyFallback)
+		require.NoError(t, err)
+		require.NotNil(t, cluster)
+		require.Equal(t, "user <*...> logged in from web", cluster.GetTemplate())
+		require.Equal(t, int64(4), cluster.Size)
+
+		parameters := miner.ExtractParameters(cluster.GetTemplate(), log)
+		require.Len(t, parameters, 1)
+		require.Equal(t, expectedParameters[i], parameters[0].Value)
+	}
+
+	// the prefix tree alone only knows templates of the same token count
+	cluster, err := drain.Match("user Anne Marie logged in from web", SearchStrategyNever)
+	require.NoError(t, err)
+	require.Nil(t, cluster)
+}
+
+func TestVariableLengthKeepsLeavesApart(t *testing.T) {
+	drain, err := NewDrain(WithVariableLength(1))
+	require.NoError(t, err)
+
+	// the prefix tree puts messages of the same length in different leaves by their first token
+	for _, log := range []string{"alpha one two", "beta one two", "beta one two three"} {
+		_, _, err := drain.AddLogMessage(log)
+		require.NoError(t, err)
+	}
+
+	templates := []string{}
+	for _, cluster := range drain.GetClusters() {
+		templates = append(templates, cluster.GetTemplate())
+	}
+	require.ElementsMatch(t, []string{"alpha one two", "beta one two <*...>"}, templates)
+}
+
+func TestNewDrainRejectsVariableLengthOptions(t *testing.T) {
+	for _, option := range []optionFn{WithKeyValueTokens(), WithTokenWeigher(NewPositionalDecayWeigher(0.5))} {
+		_, err := NewDrain(WithVariableLength(1), option)
+		require.Error(t, err)
+	}
+}
+
+func TestCreateAlignedTemplate(t *testing.T) {
+	drain, err := NewDrain(WithVariableLength(3))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		template []string
+		tokens   []string
+		expected []string
+	}{
+		{[]string{"a", "b", "c"}, []string{"a", "x", "c"}, []string{"a", "<*>", "c"}},
+		{[]string{"a", "b", "c"}, []string{"a", "x", "y", "c"}, []string{"a", "<*...>", "c"}},
+		{[]string{"a", "<*...>", "c"}, []string{"a", "c"}, []string{"a", "<*...>", "c"}},
+		{[]string{"a", "b", "c"}, []string{"a", "c"}, []string{"a", "<*...>", "c"}},
+		{[]string{"a", "<*>", "c", "d"}, []string{"a", "x", "c", "y", "z"}, []string{"a", "<*>", "c", "<*...>"}},
+	}
+
+	for _, testCase := range testCases {
+		alignment := drain.alignTokens(testCase.template, testCase.tokens, false)
+		require.Equal(t, testCase.expected, drain.createAlignedTemplate(testCase.template, testCase.tokens, alignment))
+	}
+}
+
+func TestAlignmentSearchNarrowing(t *testing.T) {
+	drain := newSyntheticDrain(t, 500, WithVariableLength(3))
+	random := rand.New(rand.NewSource(7))
+
+	for i := 0; i < 200; i++ {
+		cluster := drain.IdToCluster.Values()[random.Intn(drain.IdToCluster.Len())]
+		tokens := append([]string{}, cluster.LogTemplateTokens...)
+		tokens = append(tokens[:random.Intn(len(tokens))], "extra", fmt.Sprint(i))
+
+		// the bound never prunes a cluster the full scan would have chosen.
+		// templates of the same length are left to the prefix tree unless they have a variable parameter
+		var expected *alignment
+		for _, candidate := range drain.IdToCluster.Values() {
+			lengthDelta := int64(len(candidate.LogTemplateTokens) - len(tokens))
+			if (lengthDelta > 3 || lengthDelta < -3 || lengthDelta == 0) && !drain.hasVariableParam(candidate.LogTemplateTokens) {
+				continue
+			}
+
+			current := drain.alignTokens(candidate.LogTemplateTokens, tokens, false)
+			require.LessOrEqual(t, current.sim, drain.alignmentSimBound(candidate.LogTemplateTokens, tokens, countTokens(tokens), false))
+			if expected == nil || current.sim > expected.sim || (current.sim == expected.sim && current.paramCount > expected.paramCount) {
+				expected = current
+			}
+		}
+
+		// clusters tying on both are equally good
+		_, actual := drain.alignmentSearch("", tokens, 0, false)
+		require.Equal(t, expected.sim, actual.sim)
+		require.Equal(t, expected.paramCount, actual.paramCount)
+	}
+}
+
+func countTokens(tokens []string) map[string]int {
+	counts := map[string]int{}
+	for _, token := range tokens {
+		counts[token]++
+	}
+	return counts
+}
+
+func BenchmarkAlignmentSearch(b *testing.B) {
+	drain := newSyntheticDrain(b, 5000, WithVariableLength(3))
+	tokens := strings.Split("unseen message with a handful of tokens which no template has", " ")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		drain.alignmentSearch("", tokens, drain.SimTh, false)
+	}
+}